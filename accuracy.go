@@ -0,0 +1,134 @@
+package chess
+
+import "math"
+
+type GamePhase int
+
+const (
+	PhaseOpening GamePhase = iota + 1
+	PhaseMiddlegame
+	PhaseEndgame
+)
+
+func (p GamePhase) String() string {
+	switch p {
+	case PhaseOpening:
+		return "opening"
+	case PhaseMiddlegame:
+		return "middlegame"
+	case PhaseEndgame:
+		return "endgame"
+	default:
+		return ""
+	}
+}
+
+// Phase tells the stage of the game: the endgame once the pieces other than
+// pawns and kings are worth no more than two rooks and two bishops, as
+// Evaluate has it, else the opening for the first ten moves and the
+// middlegame after
+func (board *Board) Phase() GamePhase {
+	material := 0
+	for _, piece := range board.squares {
+		if piece.IsValid() && piece.Name != Pawn && piece.Name != King {
+			material += pieceValues[piece.Name]
+		}
+	}
+
+	switch {
+	case material <= 2*pieceValues[Rook]+2*pieceValues[Bishop]:
+		return PhaseEndgame
+	case board.FullmoveCounter <= 10:
+		return PhaseOpening
+	default:
+		return PhaseMiddlegame
+	}
+}
+
+// A player's results over the moves of a game
+type PlayerStats struct {
+	Moves        int
+	ACPL         float64 // average centipawn loss
+	Accuracy     float64 // percent, from the winning chances each move gave up
+	Inaccuracies int
+	Mistakes     int
+	Blunders     int
+
+	PhaseBlunders map[GamePhase]int
+}
+
+// GameStats sums up the analysis of a game's moves for each player
+func GameStats(game *Game, analysis []MoveAnalysis) (white, black PlayerStats, err error) {
+	board, err := game.StartingPosition()
+	if err != nil {
+		return
+	}
+
+	white.PhaseBlunders, black.PhaseBlunders = make(map[GamePhase]int), make(map[GamePhase]int)
+	var loss, accuracy [3]float64
+	for _, m := range analysis {
+		stats := &white
+		if board.SideToMove == Black {
+			stats = &black
+		}
+
+		stats.Moves++
+		loss[board.SideToMove] += float64(m.Loss)
+		if m.Loss > 0 {
+			accuracy[board.SideToMove] += moveAccuracy(m.Score, m.After)
+		} else {
+			accuracy[board.SideToMove] += 100
+		}
+		switch m.NAG {
+		case 6:
+			stats.Inaccuracies++
+		case 2:
+			stats.Mistakes++
+		case 4:
+			stats.Blunders++
+			stats.PhaseBlunders[board.Phase()]++
+		}
+
+		if err = board.PlayMove(m.Move); err != nil {
+			return
+		}
+	}
+
+	for _, side := range [...]SideColor{White, Black} {
+		stats := &white
+		if side == Black {
+			stats = &black
+		}
+		if stats.Moves > 0 {
+			stats.ACPL = loss[side] / float64(stats.Moves)
+			stats.Accuracy = accuracy[side] / float64(stats.Moves)
+		}
+	}
+	return
+}
+
+// Stats analyzes the moves played on the board, as its history has them,
+// and sums them up for each player
+func (a *Annotator) Stats(board *Board) (white, black PlayerStats, err error) {
+	game := NewGame(board)
+	analysis, err := a.Analyze(game)
+	if err != nil {
+		return
+	}
+	return GameStats(game, analysis)
+}
+
+// The chance of winning, in percent, that a score gives, on the curve lichess
+// fitted to its games
+func winPercent(s Score) float64 {
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*float64(clampCentipawns(s))))-1)
+}
+
+// The accuracy of a move, in percent, by the winning chances it lost
+func moveAccuracy(before, after Score) float64 {
+	lost := winPercent(before) - winPercent(after)
+	if lost < 0 {
+		lost = 0
+	}
+	return math.Max(0, math.Min(100, 103.1668*math.Exp(-0.04354*lost)-3.1669))
+}
@@ -0,0 +1,157 @@
+package chess
+
+import "fmt"
+
+// Scores beyond a pawn count of 10 are all the same when weighing moves
+const maxCentipawns = 1000
+
+// How one move of a game compares to the best one a search found
+type MoveAnalysis struct {
+	Move  Move
+	Best  []Move // the best line from the position before the move
+	Score Score  // of the position before the move, for the player making it
+	After Score  // of the position after the move, for the same player
+	Loss  int    // centipawns lost against the best move, at most 2000
+	NAG   int    // 6 (?!), 2 (?) or 4 (??) for inaccuracies, mistakes and blunders
+}
+
+// Marks the moves of games that lose ground against the best move a search
+// finds: inaccuracies losing at least Inaccuracy centipawns, mistakes and
+// blunders losing Mistake and Blunder
+type Annotator struct {
+	Limits     SearchLimits
+	Inaccuracy int
+	Mistake    int
+	Blunder    int
+	MaxLine    int // plies of the better line given in comments
+
+	Searcher Searcher // its zero value searches with Evaluate in real time
+}
+
+func NewAnnotator(limits SearchLimits) *Annotator {
+	return &Annotator{Limits: limits, Inaccuracy: 50, Mistake: 100, Blunder: 300, MaxLine: 6}
+}
+
+// Analyze searches every position of the game and weighs each move against
+// the best line found
+func (a *Annotator) Analyze(game *Game) ([]MoveAnalysis, error) {
+	board, err := game.StartingPosition()
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := make([]MoveAnalysis, 0, len(game.Moves))
+	score, best := a.search(board)
+	for _, move := range game.Moves {
+		if err := board.PlayMove(move); err != nil {
+			return analysis, err
+		}
+		next, nextBest := a.search(board)
+
+		m := MoveAnalysis{Move: move, Best: best, Score: score, After: -next}
+		if len(best) == 0 || !sameMove(best[0], move) {
+			if m.Loss = clampCentipawns(m.Score) - clampCentipawns(m.After); m.Loss < 0 {
+				m.Loss = 0
+			}
+		}
+		switch {
+		case m.Loss >= a.Blunder:
+			m.NAG = 4
+		case m.Loss >= a.Mistake:
+			m.NAG = 2
+		case m.Loss >= a.Inaccuracy:
+			m.NAG = 6
+		}
+		analysis = append(analysis, m)
+
+		score, best = next, nextBest
+	}
+	return analysis, nil
+}
+
+// Annotate returns a copy of the game with its inaccuracies, mistakes and
+// blunders marked and commented with the better line
+func (a *Annotator) Annotate(game *Game) (*Game, error) {
+	analysis, err := a.Analyze(game)
+	if err != nil {
+		return nil, err
+	}
+
+	// a copy that shares nothing with the game
+	annotated := Game{
+		Tags:        make(map[string]string, len(game.Tags)),
+		Moves:       append([]Move(nil), game.Moves...),
+		Result:      game.Result,
+		Annotations: make(map[int]Annotation, len(game.Annotations)),
+	}
+	for name, value := range game.Tags {
+		annotated.Tags[name] = value
+	}
+	for i, note := range game.Annotations {
+		annotated.Annotations[i] = note
+	}
+
+	board, _ := game.StartingPosition()
+	for i, m := range analysis {
+		if m.NAG != 0 {
+			// the game's own comment comes first
+			note := annotated.Annotations[i]
+			note.NAG = m.NAG
+			if note.Comment != "" {
+				note.Comment += " "
+			}
+			note.Comment += a.comment(board, m)
+			annotated.Annotations[i] = note
+		}
+		board.MakeMove(m.Move)
+	}
+	return &annotated, nil
+}
+
+// Describes a marked move played from the board, with the better line if the
+// search found one
+func (a *Annotator) comment(board *Board, m MoveAnalysis) string {
+	label := map[int]string{6: "Inaccuracy", 2: "Mistake", 4: "Blunder"}[m.NAG]
+	comment := fmt.Sprintf("%s (%s -> %s)", label, whiteScore(board, m.Score), whiteScore(board, m.After))
+
+	best := m.Best
+	if len(best) > a.MaxLine {
+		best = best[:a.MaxLine]
+	}
+	if len(best) > 0 {
+		comment += ". Better is " + board.SANLine(best)
+	}
+	return comment
+}
+
+// The score of the position for the side to move, or how the game ended if
+// it's over
+func (a *Annotator) search(board *Board) (Score, []Move) {
+	if outcome := board.Outcome(); outcome.IsOver() {
+		if outcome.Winner.IsValid() {
+			return -MateScore, nil
+		}
+		return 0, nil
+	}
+
+	result := a.Searcher.Search(board, a.Limits)
+	return result.Score, result.PV
+}
+
+func clampCentipawns(s Score) int {
+	switch {
+	case s > maxCentipawns:
+		return maxCentipawns
+	case s < -maxCentipawns:
+		return -maxCentipawns
+	}
+	return int(s)
+}
+
+// A score for the side to move given from white's point of view
+func whiteScore(board *Board, s Score) Score {
+	if board.SideToMove == Black {
+		return -s
+	}
+	return s
+}
@@ -153,7 +153,7 @@ func NewBoard(fen string) (board *Board, err error) {
 
 	matches := fenexp.FindStringSubmatch(fen)
 	if matches == nil {
-		return nil, fmt.Errorf("invalid FEN string")
+		return nil, &FENError{Value: fen}
 	}
 
 	if i := fenexp.SubexpIndex("PiecePlacement"); i != -1 && matches[i] != "" {
@@ -167,7 +167,7 @@ func NewBoard(fen string) (board *Board, err error) {
 					board.squares[r*8+f] = piece
 					f++
 				} else if symbol != '/' {
-					return nil, fmt.Errorf("unknown piece symbol: %q", symbol)
+					return nil, &FENError{"piece placement", matches[i], fmt.Sprintf("unknown piece symbol %q", symbol)}
 				}
 			}
 
@@ -177,10 +177,10 @@ func NewBoard(fen string) (board *Board, err error) {
 			}
 		}
 		if r >= 0 {
-			return nil, fmt.Errorf("not enough piece symbols")
+			return nil, &FENError{"piece placement", matches[i], "not enough piece symbols"}
 		}
 	} else {
-		return nil, fmt.Errorf("invalid or missing piece placement")
+		return nil, &FENError{"piece placement", "", "missing"}
 	}
 
 	if i := fenexp.SubexpIndex("SideToMove"); i != -1 && matches[i] != "" {
@@ -188,40 +188,40 @@ func NewBoard(fen string) (board *Board, err error) {
 			board.SideToMove = Black
 		}
 	} else {
-		return nil, fmt.Errorf("invalid or missing side to move")
+		return nil, &FENError{"side to move", "", "missing"}
 	}
 
 	if i := fenexp.SubexpIndex("Castling"); i != -1 && matches[i] != "" {
 		board.CastleRights = NewCastles(matches[i])
 	} else {
-		return nil, fmt.Errorf("invalid or missing castling rights")
+		return nil, &FENError{"castling rights", "", "missing"}
 	}
 
 	if i := fenexp.SubexpIndex("EnPassant"); i != -1 && matches[i] != "" {
 		board.EnPassantTarget = NewCoord(matches[i])
 	} else {
-		return nil, fmt.Errorf("invalid or missing en passant")
+		return nil, &FENError{"en passant target", "", "missing"}
 	}
 
 	if i := fenexp.SubexpIndex("HalfmoveClock"); i != -1 && matches[i] != "" {
 		if ply, err := strconv.Atoi(matches[i]); err == nil {
-			if ply < 0 || ply > 50 {
-				return nil, fmt.Errorf("halfmove clock out of range, [0, 50]")
+			if ply < 0 || ply > 150 { // plies, the game ends by the 75 move rule at 150
+				return nil, &FENError{"halfmove clock", matches[i], "out of range [0, 150]"}
 			}
 			board.HalfmoveClock = ply
 		} else {
-			panic("invalid halfmove clock matched regex")
+			return nil, &FENError{"halfmove clock", matches[i], "out of range"}
 		}
 	}
 
 	if i := fenexp.SubexpIndex("FullmoveCounter"); i != -1 && matches[i] != "" {
 		if counter, err := strconv.Atoi(matches[i]); err == nil {
 			if counter < 0 {
-				return nil, fmt.Errorf("fullmove counter out of range, [0, inf]")
+				return nil, &FENError{"fullmove counter", matches[i], "out of range [0, inf]"}
 			}
 			board.FullmoveCounter = counter
 		} else {
-			panic("invalid fullmove counter matched regex")
+			return nil, &FENError{"fullmove counter", matches[i], "out of range"}
 		}
 	} else {
 		board.FullmoveCounter = 1
@@ -230,6 +230,93 @@ func NewBoard(fen string) (board *Board, err error) {
 	return
 }
 
+// Parses a FEN string like NewBoard but also rejects positions that could
+// not arise in a legal game, see Board.Validate
+func NewBoardStrict(fen string) (*Board, error) {
+	board, err := NewBoard(fen)
+	if err != nil {
+		return nil, err
+	}
+	if err := board.Validate(); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+// Checks that the position could arise in a legal game: one king per side,
+// no pawns on the back ranks, no capturable king, castling rights and en
+// passant target matching the piece placement, and piece counts that
+// promotions can account for
+func (board *Board) Validate() error {
+	for _, side := range [...]SideColor{White, Black} {
+		counts := make(map[PieceName]int)
+		for _, i := range board.pieceIndices(side) {
+			counts[board.squares[i].Name]++
+		}
+
+		if counts[King] != 1 {
+			return fmt.Errorf("%w: %v has %d kings, want 1", ErrInvalidPosition, side, counts[King])
+		}
+		if counts[Pawn] > 8 {
+			return fmt.Errorf("%w: %v has %d pawns, want at most 8", ErrInvalidPosition, side, counts[Pawn])
+		}
+
+		promoted := 0
+		for name, start := range map[PieceName]int{Knight: 2, Bishop: 2, Rook: 2, Queen: 1} {
+			if counts[name] > start {
+				promoted += counts[name] - start
+			}
+		}
+		if promoted > 8-counts[Pawn] {
+			return fmt.Errorf("%w: %v has %d promoted pieces but only %d missing pawns", ErrInvalidPosition, side, promoted, 8-counts[Pawn])
+		}
+	}
+
+	for f := 1; f <= 8; f++ {
+		if board.At(Coord{f, 1}).Name == Pawn || board.At(Coord{f, 8}).Name == Pawn {
+			return fmt.Errorf("%w: pawn on the first or last rank", ErrInvalidPosition)
+		}
+	}
+
+	if board.InCheck(board.SideToMove ^ 0b11) {
+		return fmt.Errorf("%w: side not to move is in check", ErrInvalidPosition)
+	}
+
+	for _, side := range [...]SideColor{White, Black} {
+		rank := 1
+		if side == Black {
+			rank = 8
+		}
+
+		for _, castle := range [...]struct {
+			side CastleSide
+			file int
+		}{{Kingside, 8}, {Queenside, 1}} {
+			if !board.CastleRights.Can(side, castle.side) {
+				continue
+			}
+			if *board.At(Coord{5, rank}) != (Piece{side, King}) || *board.At(Coord{castle.file, rank}) != (Piece{side, Rook}) {
+				return fmt.Errorf("%w: castling rights %v without king and rook on their starting squares", ErrInvalidPosition, board.CastleRights.String())
+			}
+		}
+	}
+
+	if ep := board.EnPassantTarget; ep.IsValid() {
+		rank, dir := 6, -1
+		if board.SideToMove == Black {
+			rank, dir = 3, 1
+		}
+
+		pawn := board.At(Coord{ep.File, ep.Rank + dir})
+		if ep.Rank != rank || board.At(ep).IsValid() || board.At(Coord{ep.File, ep.Rank - dir}).IsValid() ||
+			*pawn != (Piece{board.SideToMove ^ 0b11, Pawn}) {
+			return fmt.Errorf("%w: en passant target %v without a pawn that just moved two squares", ErrInvalidPosition, ep)
+		}
+	}
+
+	return nil
+}
+
 func (board *Board) At(c Coord) *Piece {
 	if !c.IsValid() {
 		return nil
@@ -245,6 +332,25 @@ func (board *Board) History() []string {
 
 	return history
 }
+
+// Returns a deep copy of the board, history included
+func (board *Board) Clone() *Board {
+	c := *board
+	c.history = make([]BoardState, len(board.history), cap(board.history))
+	copy(c.history, board.history)
+	return &c
+}
+
+// Returns a copy of the board with the move played on it, for copy-make
+// searches. The original board is left untouched; the copy only shares
+// history entries from before the move, which neither board modifies.
+func (board *Board) Child(move Move) Board {
+	child := *board
+	n := len(board.history)
+	child.history = board.history[:n:n] // forces MakeMove to append to a new array
+	child.MakeMove(move)
+	return child
+}
 func (board *Board) pieceIndices(side SideColor, names ...PieceName) []int {
 	pieces := make([]int, 0, 16)
 
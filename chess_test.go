@@ -1,7 +1,20 @@
 package chess
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestPieceString(t *testing.T) {
@@ -436,6 +449,41 @@ func TestFENToBoard(t *testing.T) {
 	}
 }
 
+func TestBoardValidate(t *testing.T) {
+	tests := []struct {
+		fen   string
+		valid bool
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", true},
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", true},
+		{"rnbqkbnr/pppp2pp/5p2/3Pp3/8/8/PPP1PPPP/RNBQKBNR w KQkq e6 0 3", true},
+		{"4k3/8/8/8/8/8/8/8 w - - 0 1", false},               // no white king
+		{"4k3/8/8/8/8/8/8/2K1K3 w - - 0 1", false},           // two white kings
+		{"4k3/8/8/8/8/8/8/P3K3 w - - 0 1", false},            // pawn on the first rank
+		{"4k3/8/8/8/8/8/8/4K2r b - - 0 1", false},            // white king can be captured
+		{"4k3/8/8/8/8/8/8/4K3 w K - 0 1", false},             // castling without a rook
+		{"4k3/8/8/8/8/8/8/R4K1R w KQ - 0 1", false},          // castling with a moved king
+		{"4k3/8/8/3p4/8/8/8/4K3 w - e6 0 1", false},          // en passant without a pawn
+		{"4k3/8/8/8/3Pp3/8/8/4K3 w - d3 0 1", false},         // en passant on the wrong rank
+		{"QQQQkQQQ/QQQQQQQQ/8/8/8/8/8/4K3 b - - 0 1", false}, // too many promoted pieces
+	}
+
+	for _, test := range tests {
+		board, err := NewBoard(test.fen)
+		if err != nil {
+			t.Errorf("NewBoard(%q) gives error, %v", test.fen, err)
+			continue
+		}
+
+		if err := board.Validate(); (err == nil) != test.valid {
+			t.Errorf("Board.Validate() on %q = %v, want valid %v", test.fen, err, test.valid)
+		}
+		if strict, _ := NewBoardStrict(test.fen); (strict != nil) != test.valid {
+			t.Errorf("NewBoardStrict(%q) = %v, want valid %v", test.fen, strict, test.valid)
+		}
+	}
+}
+
 func TestCoord(t *testing.T) {
 	tests := []struct {
 		coord     Coord
@@ -598,8 +646,109 @@ func TestCountMoves(t *testing.T) {
 
 	for _, test := range tests[:] {
 		board, _ := NewBoard(test.position)
-		if got, breakdown := board.CountMoves(test.depth); got != test.want {
-			t.Errorf("CountMoves() on [d=%d] %q = %d, want %d\n\t%v", test.depth, test.position, got, test.want, breakdown)
+		if got := board.CountMoves(test.depth); got.Nodes != test.want {
+			t.Errorf("CountMoves() on [d=%d] %q = %d, want %d\n\t%+v", test.depth, test.position, got.Nodes, test.want, got)
+		}
+	}
+}
+
+func TestPerftStats(t *testing.T) {
+	tests := []struct {
+		position string
+		depth    int
+		want     PerftStats
+	}{
+		{
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+			4,
+			PerftStats{Nodes: 197281, Captures: 1576, Checks: 469, Checkmates: 8},
+		},
+		{
+			"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+			3,
+			PerftStats{Nodes: 97862, Captures: 17102, EnPassant: 45, Castles: 3162, Checks: 993, Checkmates: 1},
+		},
+		{
+			"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+			5,
+			PerftStats{Nodes: 674624, Captures: 52051, EnPassant: 1165, Checks: 52950, DiscoveredChecks: 1292, DoubleChecks: 3},
+		},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.position)
+		if got := board.CountMoves(test.depth); got != test.want {
+			t.Errorf("CountMoves() on [d=%d] %q =\n\t%+v, want\n\t%+v", test.depth, test.position, got, test.want)
+		}
+	}
+}
+
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		position string
+		depth    int
+		want     int
+		divide   map[string]int
+	}{
+		{
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+			3,
+			8902,
+			map[string]int{
+				"a2a3": 380, "a2a4": 420, "b1a3": 400, "b1c3": 440, "b2b3": 420, "b2b4": 421,
+				"c2c3": 420, "c2c4": 441, "d2d3": 539, "d2d4": 560, "e2e3": 599, "e2e4": 600,
+				"f2f3": 380, "f2f4": 401, "g1f3": 440, "g1h3": 400, "g2g3": 420, "g2g4": 421,
+				"h2h3": 380, "h2h4": 420,
+			},
+		},
+		{
+			"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+			3,
+			97862,
+			map[string]int{
+				"a1b1": 1969, "a1c1": 1968, "a1d1": 1885, "a2a3": 2186, "a2a4": 2149, "b2b3": 1964,
+				"c3a4": 2203, "c3b1": 2038, "c3b5": 2138, "c3d1": 2040, "d2c1": 1963, "d2e3": 2136,
+				"d2f4": 2000, "d2g5": 2134, "d2h6": 2019, "d5d6": 1991, "d5e6": 2241, "e1c1": 1887,
+				"e1d1": 1894, "e1f1": 1855, "e1g1": 2059, "e2a6": 1907, "e2b5": 2057, "e2c4": 2082,
+				"e2d1": 1733, "e2d3": 2050, "e2f1": 2060, "e5c4": 1880, "e5c6": 2027, "e5d3": 1803,
+				"e5d7": 2124, "e5f7": 2080, "e5g4": 1878, "e5g6": 1997, "f3d3": 2005, "f3e3": 2174,
+				"f3f4": 2132, "f3f5": 2396, "f3f6": 2111, "f3g3": 2214, "f3g4": 2169, "f3h3": 2360,
+				"f3h5": 2267, "g2g3": 1882, "g2g4": 1843, "g2h3": 1970, "h1f1": 1929, "h1g1": 2013,
+			},
+		},
+		{
+			"8/8/8/8/8/8/8/K1k5 w - - 0 1",
+			1,
+			1,
+			map[string]int{"a1a2": 1},
+		},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.position)
+		got, divide := board.Perft(test.depth, 4)
+		if got.Nodes != test.want {
+			t.Errorf("Perft() on [d=%d] %q = %d, want %d", test.depth, test.position, got.Nodes, test.want)
+		}
+
+		moves := make(map[string]int, len(divide))
+		for _, d := range divide {
+			moves[d.Move.UCI()] = d.Nodes
+		}
+		for move, n := range moves {
+			if want, ok := test.divide[move]; !ok {
+				t.Errorf("Perft() on [d=%d] %q: unexpected move %s", test.depth, test.position, move)
+			} else if n != want {
+				t.Errorf("Perft() on [d=%d] %q: %s = %d, want %d", test.depth, test.position, move, n, want)
+			}
+		}
+		for move := range test.divide {
+			if _, ok := moves[move]; !ok {
+				t.Errorf("Perft() on [d=%d] %q: missing move %s", test.depth, test.position, move)
+			}
+		}
+		if board.String() != test.position {
+			t.Errorf("Perft() modified the board: %q, want %q", board.String(), test.position)
 		}
 	}
 }
@@ -615,6 +764,99 @@ func TestMoveFromSAN(t *testing.T) {
 	}
 }
 
+func TestErrors(t *testing.T) {
+	fenTests := []struct {
+		fen   string
+		field string
+	}{
+		{"1p a b c d e", ""},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq - 0 1", ""},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN w KQkq - 0 1", "piece placement"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 151 1", "halfmove clock"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 99999999999999999999", "fullmove counter"},
+	}
+	for _, test := range fenTests {
+		_, err := NewBoard(test.fen)
+
+		var fenErr *FENError
+		if !errors.As(err, &fenErr) {
+			t.Errorf("NewBoard(%q) error = %v, want *FENError", test.fen, err)
+		} else if fenErr.Field != test.field {
+			t.Errorf("NewBoard(%q) error field = %q, want %q", test.fen, fenErr.Field, test.field)
+		}
+	}
+
+	if _, err := NewBoardStrict("4k3/8/8/8/8/8/8/8 w - - 0 1"); !errors.Is(err, ErrInvalidPosition) {
+		t.Errorf("NewBoardStrict() error = %v, want %v", err, ErrInvalidPosition)
+	}
+
+	moveTests := []struct {
+		position string
+		san      string
+		want     error
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e9", ErrInvalidNotation},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e5", ErrIllegalMove},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "Nc6", ErrIllegalMove},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "Nb8c6", ErrNoSuchPiece},
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", "Qd4", ErrNoSuchPiece},
+		{"4k3/8/8/8/8/4K3/8/R6R w - - 0 1", "Rd1", ErrAmbiguousMove},
+	}
+	for _, test := range moveTests {
+		board, _ := NewBoard(test.position)
+		_, err := NewMove(test.san, board)
+
+		var moveErr *MoveError
+		if !errors.Is(err, test.want) || !errors.As(err, &moveErr) || moveErr.Move != test.san {
+			t.Errorf("NewMove(%q) error = %v, want %v", test.san, err, test.want)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	tests := []struct {
+		position string
+		san      string
+		want     error
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e4", nil},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "Nf3", nil},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "O-O", ErrIllegalMove},
+		{"4k3/8/8/8/8/8/4r3/4K3 w - - 0 1", "Kxe2", nil},
+		{"4k3/8/8/8/8/8/3r4/4K3 w - - 0 1", "Ke2", ErrIllegalMove}, // moves into check
+		{"4k3/8/8/8/8/8/8/r3K2R w K - 0 1", "O-O", ErrIllegalMove}, // castles out of check
+		{"4k3/8/8/8/8/8/8/4K2R w K - 0 1", "O-O", nil},
+		{"4k3/8/8/8/8/5r2/8/4K2R w K - 0 1", "O-O", ErrIllegalMove},  // castles through check
+		{"4k3/4r3/8/8/8/8/4B3/4K3 w - - 0 1", "Bd3", ErrIllegalMove}, // pinned piece
+		{"4r2k/8/8/8/8/8/4N3/1N2K3 w - - 0 1", "Nc3", nil},           // not ambiguous with a pinned twin
+		{"4r2k/8/8/8/8/8/4N3/1N2K3 w - - 0 1", "Nec3", ErrIllegalMove},
+		{"4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8=N", nil},
+		{"4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8", ErrIllegalMove},  // missing promotion
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", "Kxe8", ErrIllegalMove}, // captures a king
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", "e4", ErrNoSuchPiece},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.position)
+
+		if _, err := board.Play(test.san); !errors.Is(err, test.want) {
+			t.Errorf("Board.Play(%q) on %q error = %v, want %v", test.san, test.position, err, test.want)
+		} else if err != nil && board.String() != test.position {
+			t.Errorf("Board.Play(%q) modified the board on error: %q", test.san, board.String())
+		} else if err == nil && len(board.History()) != 1 {
+			t.Errorf("Board.Play(%q) history = %v, want one move", test.san, board.History())
+		}
+	}
+
+	board := StartingPosition()
+	if err := board.PlayMove(Move{From: NewCoord("e1"), To: NewCoord("e2")}); !errors.Is(err, ErrIllegalMove) {
+		t.Errorf("Board.PlayMove(e1e2) error = %v, want %v", err, ErrIllegalMove)
+	}
+	if err := board.PlayMove(Move{From: NewCoord("g1"), To: NewCoord("f3")}); err != nil {
+		t.Errorf("Board.PlayMove(g1f3) error = %v", err)
+	}
+}
+
 func TestBoardHistory(t *testing.T) {
 	board := StartingPosition()
 	moves := []Move{
@@ -650,6 +892,44 @@ func TestBoardHistory(t *testing.T) {
 	}
 }
 
+func TestBoardCopies(t *testing.T) {
+	board := StartingPosition()
+	board.MakeMove(Move{From: NewCoord("e2"), To: NewCoord("e4")})
+	fen := board.String()
+
+	clone := board.Clone()
+	clone.MakeMove(Move{From: NewCoord("e7"), To: NewCoord("e5")})
+	clone.UnmakeMove()
+	clone.MakeMove(Move{From: NewCoord("c7"), To: NewCoord("c5")})
+
+	first := board.Child(Move{From: NewCoord("d7"), To: NewCoord("d5")})
+	second := board.Child(Move{From: NewCoord("g8"), To: NewCoord("f6")})
+
+	if board.String() != fen || len(board.History()) != 1 {
+		t.Errorf("board modified by its copies: %q, history %v", board.String(), board.History())
+	}
+
+	tests := []struct {
+		board *Board
+		want  []string
+	}{
+		{board, []string{"e2e4"}},
+		{clone, []string{"e2e4", "c7c5"}},
+		{&first, []string{"e2e4", "d7d5"}},
+		{&second, []string{"e2e4", "Ng8f6"}},
+	}
+	for _, test := range tests {
+		if got := test.board.History(); len(got) != len(test.want) || got[len(got)-1] != test.want[len(test.want)-1] {
+			t.Errorf("Board.History() = %v, want %v", got, test.want)
+		}
+	}
+
+	first.UnmakeMove()
+	if first.String() != fen {
+		t.Errorf("Child().UnmakeMove() = %q, want %q", first.String(), fen)
+	}
+}
+
 func BenchmarkMoveGen(b *testing.B) {
 	board, _ := NewBoard("r2qr1k1/pp3pp1/2n2n1p/2bp4/6b1/2PB1NN1/PP3PPP/R1BQR1K1 w - - 3 13")
 	for i := 0; i < b.N; i++ {
@@ -672,8 +952,7 @@ func BenchmarkCopyUndo(b *testing.B) {
 	move := Move{Coord{4, 0}, Coord{4, 8}, MoveFlags{Moves: Rook, Captures: Rook}}
 
 	for i := 0; i < b.N; i++ {
-		temp := *board
-		temp.MakeMove(move)
+		board.Child(move)
 	}
 }
 
@@ -684,3 +963,2438 @@ func BenchmarkCountMoves(b *testing.B) {
 		board.CountMoves(1)
 	}
 }
+
+func TestPolyglotKey(t *testing.T) {
+	tests := []struct {
+		moves []string
+		want  uint64
+	}{
+		{[]string{}, 0x463b96181691fc9c},
+		{[]string{"e4"}, 0x823c9b50fd114196},
+		{[]string{"e4", "d5"}, 0x0756b94461c50fb0},
+		{[]string{"e4", "d5", "e5"}, 0x662fafb965db29d4},
+		{[]string{"e4", "d5", "e5", "f5"}, 0x22a48b5a8e47ff78},
+		{[]string{"e4", "d5", "e5", "f5", "Ke2"}, 0x652a607ca3f242c1},
+		{[]string{"e4", "d5", "e5", "f5", "Ke2", "Kf7"}, 0x00fdd303c946bdd9},
+		{[]string{"a4", "b5", "h4", "b4", "c4"}, 0x3c8123ea7b067637},
+		{[]string{"a4", "b5", "h4", "b4", "c4", "bxc3", "Ra3"}, 0x5c3f9b829b279560},
+	}
+
+	for _, test := range tests {
+		board := StartingPosition()
+		for _, san := range test.moves {
+			if _, err := board.Play(san); err != nil {
+				t.Fatalf("Board.Play(%q) gives error, %v", san, err)
+			}
+		}
+
+		if got := board.PolyglotKey(); got != test.want {
+			t.Errorf("Board.PolyglotKey() after %v = %016x, want %016x", test.moves, got, test.want)
+		}
+	}
+}
+
+func TestBook(t *testing.T) {
+	castles, _ := NewBoard("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	entries := []polyglotEntry{
+		{castles.PolyglotKey(), 0x0107, 1, 0},            // e1h1
+		{castles.PolyglotKey(), 0x0100, 1, 0},            // e1a1
+		{StartingPosition().PolyglotKey(), 0x031C, 2, 0}, // e2e4
+		{StartingPosition().PolyglotKey(), 0x02DB, 1, 0}, // d2d4
+		{StartingPosition().PolyglotKey(), 0x0324, 5, 0}, // e2e5, illegal
+	}
+
+	buf := bytes.Buffer{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		binary.Write(&buf, binary.BigEndian, entries[i])
+	}
+	book, err := NewBook(&buf)
+	if err != nil {
+		t.Fatalf("NewBook() gives error, %v", err)
+	}
+
+	if got := book.Lookup(StartingPosition()); len(got) != 2 || got[0].Move.UCI() != "e2e4" || got[1].Move.UCI() != "d2d4" {
+		t.Errorf("Book.Lookup(start) = %v, want [e2e4 d2d4]", got)
+	}
+	if got := book.Lookup(castles); len(got) != 2 || !got[0].Move.CastlesTo.IsValid() || !got[1].Move.CastlesTo.IsValid() {
+		t.Errorf("Book.Lookup(%q) = %v, want both castles", castles.String(), got)
+	}
+
+	picks := make(map[string]int)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		move, ok := book.Pick(StartingPosition(), r)
+		if !ok {
+			t.Fatalf("Book.Pick(start) found no move")
+		}
+		picks[move.UCI()]++
+	}
+	if picks["e2e4"] < 150 || picks["d2d4"] < 50 || len(picks) != 2 {
+		t.Errorf("Book.Pick(start) picked %v, want about 2:1 e2e4 to d2d4", picks)
+	}
+
+	castles.MakeMove(Move{From: NewCoord("a1"), To: NewCoord("a2")})
+	if _, ok := book.Pick(castles, r); ok {
+		t.Errorf("Book.Pick() found a move for a position not in the book")
+	}
+	if _, err := NewBook(bytes.NewReader(make([]byte, 20))); err == nil {
+		t.Errorf("NewBook() of a truncated book gives no error")
+	}
+}
+
+const testPGN = `[Event "Test"]
+[White "A \"the first\""]
+[Black "B"]
+[Result "1-0"]
+
+1. e4 e5 2. Nf3 {a comment} Nc6 (2... d6 3. d4) 3. Bb5 a6 $1 4. Ba4 Nf6 5. O-O! Be7 1-0
+
+[Event "Test 2"]
+[Result "0-1"]
+1.e4 c5 2.Nf3 d6 ; a line comment
+3.d4 cxd4 4.Nxd4 Nf6 5.Nc3 a6 0-1
+
+[Event "Illegal"]
+[Result "*"]
+1. e4 e4 2. Nf3 *
+
+[Event "From FEN"]
+[FEN "4k3/P7/8/8/8/8/8/4K3 w - - 0 1"]
+[Result "1/2-1/2"]
+1. a8=Q+ Kd7 1/2-1/2
+`
+
+func TestPGNReader(t *testing.T) {
+	tests := []struct {
+		event  string
+		moves  int
+		result string
+		err    bool
+	}{
+		{"Test", 10, "1-0", false},
+		{"Test 2", 10, "0-1", false},
+		{"", 0, "", true},
+		{"From FEN", 2, "1/2-1/2", false},
+	}
+
+	pgn := NewPGNReader(strings.NewReader(testPGN))
+	for _, test := range tests {
+		game, err := pgn.Next()
+		if test.err {
+			var pgnErr *PGNError
+			if !errors.As(err, &pgnErr) || !errors.Is(err, ErrIllegalMove) || pgnErr.Game != 3 {
+				t.Errorf("PGNReader.Next() error = %v, want illegal move in game 3", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("PGNReader.Next() gives error, %v", err)
+		} else if game.Tags["Event"] != test.event || len(game.Moves) != test.moves || game.Result != test.result {
+			t.Errorf("PGNReader.Next() = %q with %d moves and result %q, want %q with %d and %q",
+				game.Tags["Event"], len(game.Moves), game.Result, test.event, test.moves, test.result)
+		}
+	}
+
+	if _, err := pgn.Next(); err != io.EOF {
+		t.Errorf("PGNReader.Next() after the last game error = %v, want EOF", err)
+	}
+}
+
+func TestBookBuilder(t *testing.T) {
+	builder := NewBookBuilder(10)
+	pgn := NewPGNReader(strings.NewReader(testPGN))
+	for {
+		game, err := pgn.Next()
+		if err == io.EOF {
+			break
+		} else if err == nil {
+			builder.AddGame(game)
+		}
+	}
+
+	if got := builder.Stats(StartingPosition())["e2e4"]; got != (BookMoveStats{Games: 2, Wins: 1, Losses: 1}) {
+		t.Errorf("BookBuilder.Stats(start)[e2e4] = %+v, want 2 games, 1 win, 1 loss", got)
+	}
+
+	buf := bytes.Buffer{}
+	if _, err := builder.Book().WriteTo(&buf); err != nil {
+		t.Fatalf("Book.WriteTo() gives error, %v", err)
+	}
+	book, err := NewBook(&buf)
+	if err != nil {
+		t.Fatalf("NewBook() gives error, %v", err)
+	}
+
+	board := StartingPosition()
+	for _, san := range []string{"e4", "e5", "Nf3", "Nc6", "Bb5", "a6", "Ba4", "Nf6"} {
+		board.Play(san)
+	}
+	if got := book.Lookup(board); len(got) != 1 || got[0].Move.CastlesTo != Kingside || got[0].Weight != 2 {
+		t.Errorf("Book.Lookup() = %v, want O-O with weight 2", got)
+	}
+
+	board.Play("O-O")
+	if got := book.Lookup(board); len(got) != 0 {
+		t.Errorf("Book.Lookup() = %v, want no moves for the loser", got)
+	}
+}
+
+// Builds Syzygy tables the way the generator lays them out, from the value of
+// each index
+type tbBuilder struct {
+	t                *tbTable
+	order, pawnOrder int
+	values           [4][2][]int // by file and side to move
+	maps             [4][4][]int // of DTZ values, by file
+}
+
+func newTBBuilder(name string, dtz bool, order, pawnOrder int) *tbBuilder {
+	b := &tbBuilder{t: newTestTable(name, dtz, order, pawnOrder), order: order, pawnOrder: pawnOrder}
+	if !b.t.hasPawns || b.t.pawns[1] == 0 {
+		b.pawnOrder = 0xf
+	}
+	b.t.each(func(d *tbPairs) {
+		for f := 0; f < b.t.files; f++ {
+			for i := 0; i < b.t.sides; i++ {
+				if b.t.parts[f][i] == d {
+					b.values[f][i] = make([]int, d.size())
+				}
+			}
+		}
+	})
+	return b
+}
+
+// A table of the ending with its pieces in the order the generator would
+// pick, without reading it from a file
+func newTestTable(name string, dtz bool, order, pawnOrder int) *tbTable {
+	ext := ".rtbw"
+	if dtz {
+		ext = ".rtbz"
+	}
+	t, ok := newTBTable(name, dtz)
+	if !ok {
+		panic("bad table name " + name)
+	}
+	t.path = name + ext
+	t.sides, t.files = 1, 1
+	if !dtz && !t.symmetric {
+		t.sides = 2
+	}
+	if t.hasPawns {
+		t.files = 4
+	}
+
+	// the leading pawns first, then the other side's, the kings, the unique
+	// pieces and the rest
+	var codes []byte
+	count := map[byte]int{}
+	for i, side := range strings.Split(name, "v") {
+		for _, c := range side {
+			code := byte(strings.IndexRune("PNBRQK", c) + 1 + 8*i)
+			codes = append(codes, code)
+			count[code]++
+		}
+	}
+	lead := byte(Pawn)
+	if count[lead] == 0 || (count[lead|8] > 0 && count[lead|8] < count[lead]) {
+		lead |= 8
+	}
+	rank := func(code byte) int {
+		switch {
+		case code == lead:
+			return 0
+		case PieceName(code&7) == Pawn:
+			return 1
+		case PieceName(code&7) == King:
+			return 2
+		case count[code] == 1:
+			return 3
+		}
+		return 4
+	}
+	sort.SliceStable(codes, func(i, j int) bool {
+		if rank(codes[i]) != rank(codes[j]) {
+			return rank(codes[i]) < rank(codes[j])
+		}
+		return codes[i] < codes[j]
+	})
+
+	if !t.hasPawns || t.pawns[1] == 0 {
+		pawnOrder = 0xf
+	}
+	for f := 0; f < t.files; f++ {
+		for i := 0; i < t.sides; i++ {
+			t.parts[f][i] = &tbPairs{}
+			copy(t.parts[f][i].pieces[:], codes)
+			t.parts[f][i].setGroups(t, order, pawnOrder, f)
+		}
+	}
+	return t
+}
+
+func (b *tbBuilder) write(dir string) error {
+	t := b.t
+	buf := bytes.Buffer{}
+	if t.dtz {
+		buf.Write(tbMagicDTZ)
+	} else {
+		buf.Write(tbMagicWDL)
+	}
+	flags := 0
+	if !t.symmetric {
+		flags |= tbSplit
+	}
+	if t.hasPawns {
+		flags |= tbHasPawns
+	}
+	buf.WriteByte(byte(flags))
+
+	for f := 0; f < t.files; f++ {
+		buf.WriteByte(byte(b.order | b.order<<4))
+		if t.hasPawns && t.pawns[1] > 0 {
+			buf.WriteByte(byte(b.pawnOrder | b.pawnOrder<<4))
+		}
+		for _, code := range t.parts[f][0].pieces[:t.pieceCount] {
+			buf.WriteByte(code | code<<4)
+		}
+	}
+	align := func(n int) {
+		for buf.Len()%n != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	align(2)
+
+	var parts []tbCompressed
+	t.each(func(d *tbPairs) {
+		file, side := len(parts)/t.sides, len(parts)%t.sides
+		parts = append(parts, compressTB(b.values[file][side], d.flags))
+		buf.Write(parts[len(parts)-1].sizes)
+	})
+
+	if t.dtz {
+		for f := 0; f < t.files; f++ {
+			flags := t.parts[f][0].flags
+			if flags&tbMapped == 0 {
+				continue
+			}
+			if flags&tbWide != 0 {
+				align(2)
+			}
+			for _, m := range b.maps[f] {
+				for _, v := range append([]int{len(m)}, m...) {
+					if flags&tbWide != 0 {
+						binary.Write(&buf, binary.LittleEndian, uint16(v))
+					} else {
+						buf.WriteByte(byte(v))
+					}
+				}
+			}
+		}
+		align(2)
+	}
+
+	for _, p := range parts {
+		buf.Write(p.sparseIndex)
+	}
+	for _, p := range parts {
+		buf.Write(p.blockLength)
+	}
+	for i, p := range parts {
+		align(64)
+		buf.Write(p.data)
+
+		// only the file's last block ends short
+		if i < len(parts)-1 {
+			align(64)
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, t.path), buf.Bytes(), 0644)
+}
+
+func appendLE(b []byte, v, size int) []byte {
+	for i := 0; i < size; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+type tbCompressed struct {
+	sizes, sparseIndex, blockLength, data []byte
+}
+
+// Compresses the values of a part of a table: pairs of them that come often
+// become a symbol of their own, and the symbols are Huffman coded, in blocks
+// of 64 bytes with the last one left short
+func compressTB(values []int, flags int) (c tbCompressed) {
+	const blockBits, spanBits, padding = 6, 5, 1
+	single := true
+	for _, v := range values {
+		single = single && v == values[0]
+	}
+	if single {
+		c.sizes = []byte{byte(flags | tbSingleValue), byte(values[0])}
+		return
+	}
+
+	type symbol struct{ left, right, n int } // right is -1 for a value
+	var syms []symbol
+	ids := map[int]int{}
+	stream := make([]int, len(values))
+	for i, v := range values {
+		id, ok := ids[v]
+		if !ok {
+			id = len(syms)
+			ids[v] = id
+			syms = append(syms, symbol{v, -1, 1})
+		}
+		stream[i] = id
+	}
+
+	for round := 0; round < 8; round++ {
+		pairs := map[[2]int]int{}
+		for i := 0; i+1 < len(stream); i++ {
+			if syms[stream[i]].n+syms[stream[i+1]].n <= 64 {
+				pairs[[2]int{stream[i], stream[i+1]}]++
+			}
+		}
+		best, most := [2]int{}, 1
+		for pair, n := range pairs {
+			if n > most || (n == most && (pair[0] < best[0] || (pair[0] == best[0] && pair[1] < best[1]))) {
+				best, most = pair, n
+			}
+		}
+		if most < 2 {
+			break
+		}
+
+		paired := make([]int, 0, len(stream))
+		distinct := map[int]bool{}
+		for i := 0; i < len(stream); i++ {
+			s := stream[i]
+			if i+1 < len(stream) && s == best[0] && stream[i+1] == best[1] {
+				s = len(syms)
+				i++
+			}
+			paired = append(paired, s)
+			distinct[s] = true
+		}
+		if len(distinct) < 2 {
+			break
+		}
+		syms = append(syms, symbol{best[0], best[1], syms[best[0]].n + syms[best[1]].n})
+		stream = paired
+	}
+
+	// Huffman code lengths
+	freq := make([]int, len(syms))
+	for _, s := range stream {
+		freq[s]++
+	}
+	type node struct {
+		weight int
+		syms   []int
+	}
+	var nodes []node
+	for s, f := range freq {
+		if f > 0 {
+			nodes = append(nodes, node{f, []int{s}})
+		}
+	}
+	length := make([]int, len(syms))
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		a, b := nodes[0], nodes[1]
+		for _, s := range append(a.syms, b.syms...) {
+			length[s]++
+		}
+		nodes = append(nodes[2:], node{a.weight + b.weight, append(append([]int(nil), a.syms...), b.syms...)})
+	}
+
+	// symbols are numbered by their code's length, longest first, and the
+	// ones only pairs use come last
+	order := make([]int, len(syms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		li, lj := length[order[i]], length[order[j]]
+		if li == 0 || lj == 0 {
+			return li != 0 && lj == 0
+		}
+		return li > lj
+	})
+	id := make([]int, len(syms))
+	for i, s := range order {
+		id[s] = i
+	}
+
+	minLen, maxLen := 64, 0
+	for _, l := range length {
+		if l > 0 && l < minLen {
+			minLen = l
+		}
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	n := maxLen - minLen + 1
+	counts := make([]int, n)
+	for _, l := range length {
+		if l > 0 {
+			counts[l-minLen]++
+		}
+	}
+	lowest, base := make([]int, n), make([]uint64, n)
+	for i := n - 2; i >= 0; i-- {
+		lowest[i] = lowest[i+1] + counts[i+1]
+		base[i] = (base[i+1] + uint64(counts[i+1])) / 2
+	}
+
+	var blockLengths []int
+	block, bits, vals := make([]byte, 1<<blockBits), 0, 0
+	finish := func(last bool) {
+		if last {
+			block = block[:(bits+7)/8]
+		}
+		c.data = append(c.data, block...)
+		blockLengths = append(blockLengths, vals-1)
+		block, bits, vals = make([]byte, 1<<blockBits), 0, 0
+	}
+	for _, s := range stream {
+		l := length[s]
+		if bits+l > 8<<blockBits {
+			finish(false)
+		}
+		code := base[l-minLen] + uint64(id[s]-lowest[l-minLen])
+		for k := l - 1; k >= 0; k-- {
+			if code>>k&1 != 0 {
+				block[bits/8] |= 0x80 >> (bits % 8)
+			}
+			bits++
+		}
+		vals += syms[s].n
+	}
+	finish(true)
+
+	start := 0
+	span := 1 << spanBits
+	for k, blk := 0, 0; k*span < len(values); k++ {
+		target := k*span + span/2
+		for blk+1 < len(blockLengths) && start+blockLengths[blk]+1 <= target {
+			start += blockLengths[blk] + 1
+			blk++
+		}
+		c.sparseIndex = appendLE(c.sparseIndex, blk, 4)
+		c.sparseIndex = appendLE(c.sparseIndex, target-start, 2)
+	}
+	for _, l := range append(blockLengths, make([]int, padding)...) {
+		c.blockLength = appendLE(c.blockLength, l, 2)
+	}
+
+	c.sizes = []byte{byte(flags), blockBits, spanBits, padding}
+	c.sizes = appendLE(c.sizes, len(blockLengths), 4)
+	c.sizes = append(c.sizes, byte(maxLen), byte(minLen))
+	for _, l := range lowest {
+		c.sizes = appendLE(c.sizes, l, 2)
+	}
+	c.sizes = appendLE(c.sizes, len(syms), 2)
+	for _, s := range order {
+		left, right := syms[s].left, 0xfff
+		if syms[s].right != -1 {
+			left, right = id[syms[s].left], id[syms[s].right]
+		}
+		c.sizes = append(c.sizes, byte(left), byte(left>>8&0xf|right<<4), byte(right>>4))
+	}
+	if len(syms)%2 != 0 {
+		c.sizes = append(c.sizes, 0)
+	}
+	return
+}
+
+// An ending solved by retrograde analysis, with the value and DTZ of each
+// position for the side to move, by its index in the WDL table
+type tbSolution struct {
+	wdl, dtz *tbBuilder
+	base     [4][2]int // where the states of each part start
+	value    []int     // 1 for a win, -1 for a loss
+	plies    []int
+	samples  []*Board // some of the legal positions
+}
+
+// Solves the ending with white as its first side, probing the tables for the
+// positions its captures and promotions lead to
+func solveTB(t *testing.T, tb *Tablebase, name string) *tbSolution {
+	s := &tbSolution{wdl: newTBBuilder(name, false, 0, 1), dtz: newTBBuilder(name, true, 0, 1)}
+	w := s.wdl.t
+	states := 0
+	for f := 0; f < w.files; f++ {
+		for i := 0; i < w.sides; i++ {
+			s.base[f][i] = states
+			states += len(s.wdl.values[f][i])
+		}
+	}
+
+	type child struct {
+		state   int // -1 for one in another table
+		value   int // of the other table's position
+		zeroing bool
+	}
+	children := make([][]child, states)
+	legal, mated := make([]bool, states), make([]bool, states)
+	seen := make([]bool, states)
+
+	var pieces []Piece
+	for i, side := range strings.Split(name, "v") {
+		for _, c := range side {
+			pieces = append(pieces, Piece{SideColor(i + 1), PieceName(strings.IndexRune("PNBRQK", c) + 1)})
+		}
+	}
+
+	board, _ := NewBoard("")
+	state := func() int {
+		_, f, side, idx := w.index(board, false)
+		return s.base[f][side] + int(idx)
+	}
+	visit := func() {
+		for _, side := range []SideColor{White, Black} {
+			board.SideToMove = side
+			st := state()
+			if seen[st] {
+				continue
+			}
+			seen[st] = true
+			if legal[st] = !board.InCheck(side ^ 0b11); !legal[st] {
+				continue
+			}
+			if len(s.samples) < 2000 && st%32 == 0 {
+				s.samples = append(s.samples, board.Clone())
+			}
+
+			moves := board.Moves()
+			mated[st] = len(moves) == 0 && board.InCheck(side)
+			for _, move := range moves {
+				actual := board.MakeMove(move)
+				c := child{state: -1, zeroing: tbZeroing(actual)}
+				if tbCapture(actual) || actual.PromotesTo != 0 {
+					wdl, err := tb.ProbeWDL(board)
+					if err != nil {
+						t.Fatalf("Tablebase.ProbeWDL(%v) gives error, %v", board, err)
+					}
+					c.value = tbSign(int(wdl))
+				} else {
+					c.state = state()
+				}
+				board.UnmakeMove()
+				children[st] = append(children[st], c)
+			}
+		}
+	}
+
+	var place func(i int)
+	place = func(i int) {
+		if i == len(pieces) {
+			visit()
+			return
+		}
+		for sq := 0; sq < 64; sq++ {
+			if board.squares[sq].IsValid() || (pieces[i].Name == Pawn && (sq < 8 || sq >= 56)) {
+				continue
+			}
+			board.squares[sq] = pieces[i]
+			place(i + 1)
+			board.squares[sq] = Piece{}
+		}
+	}
+	place(0)
+
+	value := func(c child) int {
+		if c.state == -1 {
+			return c.value
+		}
+		return s.value[c.state]
+	}
+
+	// wins have a move to a loss, losses only moves to wins
+	s.value = make([]int, states)
+	for st := range s.value {
+		if mated[st] {
+			s.value[st] = -1
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for st, moves := range children {
+			if !legal[st] || s.value[st] != 0 || len(moves) == 0 {
+				continue
+			}
+			win, loss := false, true
+			for _, c := range moves {
+				win = win || value(c) < 0
+				loss = loss && value(c) > 0
+			}
+			if win {
+				s.value[st], changed = 1, true
+			} else if loss {
+				s.value[st], changed = -1, true
+			}
+		}
+	}
+
+	// then the plies to a zeroing move or mate, a ply further each pass
+	s.plies = make([]int, states)
+	for st := range s.plies {
+		if mated[st] {
+			s.plies[st] = -1
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		next := append([]int(nil), s.plies...)
+		for st, moves := range children {
+			if s.value[st] == 0 || s.plies[st] != 0 {
+				continue
+			}
+
+			if s.value[st] > 0 {
+				best := 0
+				for _, c := range moves {
+					plies := 0
+					switch {
+					case c.zeroing && value(c) < 0, c.state != -1 && mated[c.state]:
+						plies = 1
+					case c.state != -1 && s.value[c.state] < 0 && s.plies[c.state] != 0:
+						plies = -s.plies[c.state] + 1
+					}
+					if plies != 0 && (best == 0 || plies < best) {
+						best = plies
+					}
+				}
+				next[st] = best
+			} else {
+				worst := 1
+				for _, c := range moves {
+					if c.zeroing {
+						continue
+					}
+					if s.plies[c.state] == 0 {
+						worst = 0
+						break
+					}
+					if s.plies[c.state]+1 > worst {
+						worst = s.plies[c.state] + 1
+					}
+				}
+				next[st] = -worst
+			}
+			changed = changed || next[st] != 0
+		}
+		s.plies = next
+	}
+
+	for f := 0; f < w.files; f++ {
+		s.dtz.t.parts[f][0].flags = tbWinPlies | tbLossPlies
+		for i := 0; i < w.sides; i++ {
+			for idx := range s.wdl.values[f][i] {
+				st := s.base[f][i] + idx
+				s.wdl.values[f][i][idx] = 2*s.value[st] + 2
+				if s.plies[st] > 100 || s.plies[st] < -100 || (s.value[st] != 0 && s.plies[st] == 0) {
+					t.Fatalf("%s state %d has value %d and DTZ %d, want a win or loss within 100 plies", name, st, s.value[st], s.plies[st])
+				}
+				if i == 0 && s.value[st] != 0 {
+					s.dtz.values[f][0][idx] = tbSign(s.value[st])*s.plies[st] - 1
+				}
+			}
+		}
+	}
+	return s
+}
+
+// The value and DTZ the solution has for the position, which has white as the
+// ending's first side
+func (s *tbSolution) lookup(board *Board) (WDL, int) {
+	_, f, side, idx := s.wdl.t.index(board, false)
+	st := s.base[f][side] + int(idx)
+	return WDL(2 * s.value[st]), s.plies[st]
+}
+
+// The position with the colors swapped and the board mirrored
+func flipColors(board *Board) *Board {
+	flipped := board.Clone()
+	for sq, piece := range board.squares {
+		if piece.IsValid() {
+			piece.Color ^= 0b11
+		}
+		flipped.squares[sq^56] = piece
+	}
+	flipped.SideToMove ^= 0b11
+	return flipped
+}
+
+// Positions whose verdicts and DTZ are known, with both colors
+var tablebaseTests = []struct {
+	fen string
+	wdl WDL
+	dtz int
+}{
+	{"7k/8/5K2/8/8/8/8/6Q1 w - - 0 1", WDLWin, 1},
+	{"7k/6Q1/6K1/8/8/8/8/8 b - - 0 1", WDLLoss, -1},
+	{"7k/8/5KQ1/8/8/8/8/8 b - - 0 1", WDLDraw, 0},
+	{"8/8/8/8/8/2k5/1Q6/7K b - - 0 1", WDLDraw, 0},
+	{"4k3/R7/4K3/8/8/8/8/8 w - - 0 1", WDLWin, 1},
+	{"8/8/8/8/8/8/8/KB5k w - - 0 1", WDLDraw, 0},
+	{"8/8/8/8/8/8/8/KN5k b - - 0 1", WDLDraw, 0},
+	{"8/4P3/8/8/8/k7/8/K7 w - - 0 1", WDLWin, 1},
+	{"8/4P3/8/8/8/k7/8/K7 b - - 0 1", WDLLoss, -2},
+	{"k7/8/8/8/8/8/P7/K7 w - - 0 1", WDLDraw, 0},
+	{"8/8/8/8/8/8/kP6/4K3 b - - 0 1", WDLDraw, 0},
+	{"8/8/8/8/8/8/8/K6k w - - 0 1", WDLDraw, 0},
+}
+
+func TestTablebase(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *Tablebase {
+		tb, err := OpenTablebase(dir)
+		if err != nil {
+			t.Fatalf("OpenTablebase() gives error, %v", err)
+		}
+		t.Cleanup(func() { tb.Close() })
+		return tb
+	}
+
+	// a bishop or knight can't mate, so every position is a draw
+	for _, name := range []string{"KBvK", "KNvK"} {
+		for _, dtz := range []bool{false, true} {
+			b := newTBBuilder(name, dtz, 0, 0xf)
+			for i, values := range b.values[0][:b.t.sides] {
+				for idx := range values {
+					b.values[0][i][idx] = 2
+				}
+			}
+			if err := b.write(dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	solutions := map[string]*tbSolution{}
+	for _, name := range []string{"KQvK", "KRvK", "KPvK"} {
+		s := solveTB(t, open(), name)
+		if err := s.wdl.write(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.dtz.write(dir); err != nil {
+			t.Fatal(err)
+		}
+		solutions[name] = s
+	}
+
+	// the longest wins are mates in 10 and 16 moves
+	for name, want := range map[string]int{"KQvK": 19, "KRvK": 31} {
+		longest := 0
+		for _, plies := range solutions[name].plies {
+			if plies > longest {
+				longest = plies
+			}
+		}
+		if longest != want {
+			t.Errorf("%s longest win = %d plies, want %d", name, longest, want)
+		}
+	}
+
+	tb := open()
+	if tb.MaxPieces != 3 {
+		t.Errorf("Tablebase.MaxPieces = %d, want 3", tb.MaxPieces)
+	}
+	for name, s := range solutions {
+		for _, board := range s.samples {
+			wantWDL, wantDTZ := s.lookup(board)
+			for _, b := range []*Board{board, flipColors(board)} {
+				if got, err := tb.ProbeWDL(b); got != wantWDL || err != nil {
+					t.Errorf("%s: Tablebase.ProbeWDL(%v) = %v, %v, want %v", name, b, got, err, wantWDL)
+				}
+				if got, err := tb.ProbeDTZ(b); got != wantDTZ || err != nil {
+					t.Errorf("%s: Tablebase.ProbeDTZ(%v) = %d, %v, want %d", name, b, got, err, wantDTZ)
+				}
+			}
+		}
+	}
+
+	for _, test := range tablebaseTests {
+		board, _ := NewBoard(test.fen)
+		for _, b := range []*Board{board, flipColors(board)} {
+			if got, err := tb.ProbeWDL(b); got != test.wdl || err != nil {
+				t.Errorf("Tablebase.ProbeWDL(%v) = %v, %v, want %v", b, got, err, test.wdl)
+			}
+			if got, err := tb.ProbeDTZ(b); got != test.dtz || err != nil {
+				t.Errorf("Tablebase.ProbeDTZ(%v) = %d, %v, want %d", b, got, err, test.dtz)
+			}
+		}
+	}
+
+	rootTests := []struct {
+		fen            string
+		want, unwanted []string
+	}{
+		// every win, but not the stalemate or the queen left to be taken
+		{"7k/8/5K2/8/8/8/8/6Q1 w - - 0 1", []string{"g1g7", "g1g2"}, []string{"g1g6", "g1g8"}},
+		{"7k/8/5K2/8/8/8/8/6Q1 b - - 0 1", []string{"h8h7"}, nil},
+		{"8/8/8/8/8/2k5/1Q6/7K b - - 0 1", []string{"c3b2"}, nil},
+		{"8/4P3/8/8/8/k7/8/K7 w - - 0 1", []string{"e7e8q", "e7e8r", "a1b1"}, []string{"e7e8b", "e7e8n"}},
+	}
+	for _, test := range rootTests {
+		board, _ := NewBoard(test.fen)
+		moves, err := tb.RootMoves(board)
+		if err != nil {
+			t.Errorf("Tablebase.RootMoves(%s) gives error, %v", test.fen, err)
+			continue
+		}
+		got := map[string]bool{}
+		for _, move := range moves {
+			got[move.UCI()] = true
+		}
+		for _, uci := range test.want {
+			if !got[uci] {
+				t.Errorf("Tablebase.RootMoves(%s) = %v, want %s among them", test.fen, moves, uci)
+			}
+		}
+		for _, uci := range test.unwanted {
+			if got[uci] {
+				t.Errorf("Tablebase.RootMoves(%s) = %v, want no %s", test.fen, moves, uci)
+			}
+		}
+		if test.unwanted == nil && len(moves) != len(test.want) {
+			t.Errorf("Tablebase.RootMoves(%s) = %v, want only %v", test.fen, moves, test.want)
+		}
+	}
+
+	// only the mate when the fifty move rule is near
+	board, _ := NewBoard("7k/8/5K2/8/8/8/8/6Q1 w - - 0 80")
+	board.HalfmoveClock = 98
+	if moves, err := tb.RootMoves(board); err != nil || len(moves) != 1 || moves[0].UCI() != "g1g7" {
+		t.Errorf("Tablebase.RootMoves(%v) = %v, %v, want only g1g7", board, moves, err)
+	}
+
+	for _, fen := range []string{
+		"4k2r/8/8/8/8/8/8/4K3 w k - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+	} {
+		board, _ := NewBoard(fen)
+		if _, err := tb.ProbeWDL(board); !errors.Is(err, ErrNotInTablebase) {
+			t.Errorf("Tablebase.ProbeWDL(%s) gives error %v, want ErrNotInTablebase", fen, err)
+		}
+	}
+
+	// a missing table, and one that isn't a table
+	other := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(dir, "KQvK.rtbw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(other, "KQvK.rtbw"), data, 0644)
+	os.WriteFile(filepath.Join(other, "KRvK.rtbw"), []byte("not a table"), 0644)
+	tb, err = OpenTablebase(other)
+	if err != nil {
+		t.Fatalf("OpenTablebase() gives error, %v", err)
+	}
+	defer tb.Close()
+
+	board, _ = NewBoard("7k/8/5K2/8/8/8/8/6Q1 w - - 0 1")
+	if got, err := tb.ProbeWDL(board); got != WDLWin || err != nil {
+		t.Errorf("Tablebase.ProbeWDL(%v) = %v, %v, want win", board, got, err)
+	}
+	board, _ = NewBoard("8/8/8/8/8/8/8/KB5k w - - 0 1")
+	if _, err := tb.ProbeWDL(board); !errors.Is(err, ErrNotInTablebase) {
+		t.Errorf("Tablebase.ProbeWDL(%v) gives error %v, want ErrNotInTablebase", board, err)
+	}
+	board, _ = NewBoard("4k3/R7/4K3/8/8/8/8/8 w - - 0 1")
+	if _, err := tb.ProbeWDL(board); err == nil || errors.Is(err, ErrNotInTablebase) {
+		t.Errorf("Tablebase.ProbeWDL(%v) gives error %v, want a bad table", board, err)
+	}
+}
+
+// Probes real tables, such as the ones the Syzygy project publishes, when
+// SYZYGY_PATH names their directory. DTZ may be a move more than known, for
+// tables that don't keep plies.
+func TestTablebaseFiles(t *testing.T) {
+	dir := os.Getenv("SYZYGY_PATH")
+	if dir == "" {
+		t.Skip("SYZYGY_PATH is not set")
+	}
+	tb, err := OpenTablebase(dir)
+	if err != nil {
+		t.Fatalf("OpenTablebase(%q) gives error, %v", dir, err)
+	}
+	defer tb.Close()
+
+	for _, test := range tablebaseTests {
+		board, _ := NewBoard(test.fen)
+		for _, b := range []*Board{board, flipColors(board)} {
+			got, err := tb.ProbeWDL(b)
+			if errors.Is(err, ErrNotInTablebase) {
+				continue // the directory doesn't have the ending
+			}
+			if got != test.wdl || err != nil {
+				t.Errorf("Tablebase.ProbeWDL(%v) = %v, %v, want %v", b, got, err, test.wdl)
+			}
+
+			extra := 0
+			if test.dtz > 0 {
+				extra = 1
+			} else if test.dtz < 0 {
+				extra = -1
+			}
+			if got, err := tb.ProbeDTZ(b); (got != test.dtz && got != test.dtz+extra) || err != nil {
+				t.Errorf("Tablebase.ProbeDTZ(%v) = %d, %v, want %d", b, got, err, test.dtz)
+			}
+		}
+	}
+}
+
+// The images of the position under the board's symmetries, which are only
+// mirroring the files with pawns on it
+func tbSymmetries(board *Board, pawns bool) []*Board {
+	var images []*Board
+	for i := 1; i < 8; i++ {
+		if pawns && i != 1 {
+			break
+		}
+		image := board.Clone()
+		for sq, piece := range board.squares {
+			if i&4 != 0 {
+				sq = (sq>>3 | sq<<3) & 63
+			}
+			image.squares[sq^(i&1*7)^(i&2*28)] = piece
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+func TestTablebaseIndex(t *testing.T) {
+	tests := []struct {
+		name             string
+		order, pawnOrder int
+	}{
+		{"KQvK", 0, 0xf},
+		{"KRvKN", 1, 0xf},
+		{"KRRvK", 0, 0xf},
+		{"KRRvKNN", 2, 0xf},
+		{"KPvK", 0, 0xf},
+		{"KRPvKR", 2, 0xf},
+		{"KPvKP", 0, 1},
+		{"KPPvKP", 1, 0},
+		{"KPPPvKPP", 3, 0},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		table := newTestTable(test.name, false, test.order, test.pawnOrder)
+		var pieces []Piece
+		for i, side := range strings.Split(test.name, "v") {
+			for _, c := range side {
+				pieces = append(pieces, Piece{SideColor(i + 1), PieceName(strings.IndexRune("PNBRQK", c) + 1)})
+			}
+		}
+
+		for n := 0; n < 2000; n++ {
+			board, _ := NewBoard("")
+			board.SideToMove = SideColor(r.Intn(2) + 1)
+			for _, piece := range pieces {
+				sq := r.Intn(64)
+				for board.squares[sq].IsValid() || (piece.Name == Pawn && (sq < 8 || sq >= 56)) {
+					sq = r.Intn(64)
+				}
+				board.squares[sq] = piece
+			}
+
+			d, file, side, idx := table.index(board, false)
+			if idx >= d.size() {
+				t.Errorf("%s: index(%v) = %d, want less than %d", test.name, board, idx, d.size())
+			}
+			if d2, file2, side2, idx2 := table.index(flipColors(board), true); d2 != d || file2 != file || side2 != side || idx2 != idx {
+				t.Errorf("%s: index(%v) with the colors flipped = %d, want %d", test.name, board, idx2, idx)
+			}
+
+			// the leading pieces settle the symmetry, unless they're all on a
+			// diagonal or the leading pawn's mirror image is a leading pawn
+			ambiguous, lead := !table.hasPawns, -1
+			for sq, piece := range board.squares {
+				if !piece.IsValid() || !bytes.Contains(d.pieces[:d.groupLen[0]], []byte{tbPieceCode(piece)}) {
+					continue
+				}
+				if table.hasPawns {
+					if lead == -1 || tbMapPawns[sq] > tbMapPawns[lead] {
+						lead = sq
+					}
+				} else if sq>>3 != sq&7 && sq>>3 != 7-sq&7 {
+					ambiguous = false
+				}
+			}
+			if table.hasPawns {
+				ambiguous = board.squares[lead^7] == board.squares[lead]
+			}
+			if ambiguous {
+				continue
+			}
+			for _, image := range tbSymmetries(board, table.hasPawns) {
+				if _, _, _, got := table.index(image, false); got != idx {
+					t.Errorf("%s: index(%v) = %d, want %d as for %v", test.name, image, got, idx, board)
+				}
+			}
+		}
+	}
+}
+
+func TestTablebaseValues(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+
+	wdl := newTBBuilder("KRvK", false, 0, 0xf)
+	dtz := newTBBuilder("KRvK", true, 0, 0xf)
+	for i := 0; i < 2; i++ {
+		for idx := range wdl.values[0][i] {
+			wdl.values[0][i][idx] = r.Intn(5)
+		}
+	}
+	for idx := range dtz.values[0][0] {
+		dtz.values[0][0][idx] = r.Intn(16)
+	}
+	dtz.t.parts[0][0].flags = tbMapped | tbSTM
+	for i := range dtz.maps[0] {
+		for j := 0; j < 16; j++ {
+			dtz.maps[0][i] = append(dtz.maps[0][i], r.Intn(256))
+		}
+	}
+
+	// a single value, mapped to values too large for a byte
+	wide := newTBBuilder("KQvK", true, 0, 0xf)
+	wide.t.parts[0][0].flags = tbMapped | tbWide | tbWinPlies
+	for i := range wide.maps[0] {
+		wide.maps[0][i] = []int{1000 + i, 2000 + i, 3000 + i}
+	}
+	for idx := range wide.values[0][0] {
+		wide.values[0][0][idx] = 2
+	}
+
+	for _, b := range []*tbBuilder{wdl, dtz, wide} {
+		if err := b.write(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tb, err := OpenTablebase(dir)
+	if err != nil {
+		t.Fatalf("OpenTablebase() gives error, %v", err)
+	}
+	defer tb.Close()
+
+	tables := map[*tbBuilder]*tbTable{wdl: tb.tables["KRvK.rtbw"], dtz: tb.tables["KRvK.rtbz"], wide: tb.tables["KQvK.rtbz"]}
+	for _, table := range tables {
+		if err := table.open(); err != nil {
+			t.Fatalf("opening %s gives error, %v", table.path, err)
+		}
+	}
+
+	for n := 0; n < 2000; n++ {
+		b := []*tbBuilder{wdl, dtz, wide}[n%3]
+		board, _ := NewBoard("")
+		board.SideToMove = SideColor(r.Intn(2) + 1)
+		for _, piece := range []Piece{{White, King}, {Black, King}, {White, Rook}} {
+			if b == wide && piece.Name == Rook {
+				piece.Name = Queen
+			}
+			sq := r.Intn(64)
+			for board.squares[sq].IsValid() {
+				sq = r.Intn(64)
+			}
+			board.squares[sq] = piece
+		}
+		wdlValue := WDL(r.Intn(4) - 2)
+		if wdlValue >= WDLDraw {
+			wdlValue++
+		}
+
+		_, file, side, idx := b.t.index(board, false)
+		stored := b.values[file][side%b.t.sides][idx]
+		got, otherSide, err := tables[b].probe(board, false, wdlValue)
+		if err != nil {
+			t.Errorf("probing %s for %v gives error, %v", b.t.path, board, err)
+			continue
+		}
+
+		want, wantOtherSide := stored-2, false
+		if b.t.dtz {
+			want = b.maps[file][[...]int{1, 3, 0, 2, 0}[wdlValue+2]][stored]
+			if b.t.parts[file][0].flags&tbWinPlies == 0 || wdlValue != WDLWin {
+				want *= 2
+			}
+			want++
+			wantOtherSide = side != b.t.parts[file][0].flags&tbSTM
+		}
+		if wantOtherSide {
+			if !otherSide {
+				t.Errorf("probing %s for %v = %d, want the other side to move", b.t.path, board, got)
+			}
+		} else if got != want || otherSide {
+			t.Errorf("probing %s for %v as a %v = %d, %v, want %d", b.t.path, board, wdlValue, got, otherSide, want)
+		}
+	}
+
+	// a table cut short
+	data, err := os.ReadFile(filepath.Join(dir, "KRvK.rtbw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := t.TempDir()
+	os.WriteFile(filepath.Join(short, "KRvK.rtbw"), data[:40], 0644)
+	tb, err = OpenTablebase(short)
+	if err != nil {
+		t.Fatalf("OpenTablebase() gives error, %v", err)
+	}
+	defer tb.Close()
+	board, _ := NewBoard("4k3/R7/4K3/8/8/8/8/8 b - - 0 1")
+	if _, err := tb.ProbeWDL(board); err == nil || errors.Is(err, ErrNotInTablebase) {
+		t.Errorf("Tablebase.ProbeWDL(%v) gives error %v, want a bad table", board, err)
+	}
+}
+
+func TestClassifyOpening(t *testing.T) {
+	tests := []struct {
+		moves []string
+		eco   string
+		name  string
+		ply   int
+	}{
+		{[]string{"e4", "c5", "Nf3", "d6", "d4", "cxd4", "Nxd4", "Nf6", "Nc3", "a6", "h3"}, "B90", "Sicilian Defense", 10},
+		{[]string{"e4", "e5", "Nf3", "Nc6", "Bb5", "a6", "Ba4", "Nf6", "O-O", "Be7"}, "C84", "Ruy Lopez", 10},
+		{[]string{"c4", "e6", "Nc3", "Nf6", "d4", "Bb4"}, "E20", "Nimzo-Indian Defense", 6}, // transposes from 1. d4
+		{[]string{"d4", "Nf6", "c4", "e6", "Nc3", "d5"}, "D35", "Queen's Gambit Declined", 6},
+		{[]string{"a3", "a6", "a4"}, "A00", "Anderssen's Opening", 1},
+		{[]string{}, "", "", 0},
+	}
+
+	for _, test := range tests {
+		board := StartingPosition()
+		for _, san := range test.moves {
+			if _, err := board.Play(san); err != nil {
+				t.Fatalf("Board.Play(%q) gives error, %v", san, err)
+			}
+		}
+
+		got, ok := ClassifyOpening(board)
+		if ok != (test.eco != "") || got.ECO != test.eco || got.Name != test.name || got.Ply != test.ply {
+			t.Errorf("ClassifyOpening(%v) = %+v, want %s %s at ply %d", test.moves, got, test.eco, test.name, test.ply)
+		}
+	}
+
+	game, err := NewPGNReader(strings.NewReader(testPGN)).Next()
+	if err != nil {
+		t.Fatalf("PGNReader.Next() gives error, %v", err)
+	}
+	if game.ClassifyOpening(); game.Tags["ECO"] != "C84" || game.Tags["Opening"] != "Ruy Lopez" || game.Tags["Variation"] != "Closed" {
+		t.Errorf("Game.ClassifyOpening() tags = %v, want C84 Ruy Lopez: Closed", game.Tags)
+	}
+
+	// a game without tags gets them, and one out of the table loses stale ones
+	e4 := Move{From: NewCoord("e2"), To: NewCoord("e4")}
+	game = &Game{Moves: []Move{e4}}
+	if err := game.ClassifyOpening(); err != nil || game.Tags["ECO"] == "" {
+		t.Errorf("Game{}.ClassifyOpening() = %v, tags %v, want an ECO tag", err, game.Tags)
+	}
+	game = &Game{Tags: map[string]string{"ECO": "C84", "Opening": "Ruy Lopez", "Variation": "Closed", "FEN": "4k3/8/8/8/8/8/8/4K3 w - - 0 1"}}
+	if game.ClassifyOpening(); len(game.Tags) != 1 {
+		t.Errorf("Game.ClassifyOpening() off the table left tags %v, want only FEN", game.Tags)
+	}
+}
+
+func TestEPD(t *testing.T) {
+	const wac = `2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`
+	epd, err := ParseEPD(wac)
+	if err != nil {
+		t.Fatalf("ParseEPD(%q) gives error, %v", wac, err)
+	}
+	if got := epd.String(); got != wac {
+		t.Errorf("EPD.String() = %q, want %q", got, wac)
+	}
+	if epd.ID() != "WAC.001" {
+		t.Errorf("EPD.ID() = %q, want %q", epd.ID(), "WAC.001")
+	}
+	if bm, err := epd.BestMoves(); err != nil || len(bm) != 1 || bm[0].UCI() != "g3g6" {
+		t.Errorf("EPD.BestMoves() = %v, %v, want [g3g6]", bm, err)
+	}
+
+	const analysed = `r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - am Qe2 Ke2; acd 12; ce -15; pv Bb5 a6 Ba4; c0 "main line; mostly"; hmvc 2; fmvn 3;`
+	if epd, err = ParseEPD(analysed); err != nil {
+		t.Fatalf("ParseEPD(%q) gives error, %v", analysed, err)
+	}
+	if got := epd.String(); got != analysed {
+		t.Errorf("EPD.String() = %q, want %q", got, analysed)
+	}
+	if epd.Board.HalfmoveClock != 2 || epd.Board.FullmoveCounter != 3 {
+		t.Errorf("ParseEPD() counters = %d %d, want 2 3", epd.Board.HalfmoveClock, epd.Board.FullmoveCounter)
+	}
+	if epd.Comment() != "main line; mostly" {
+		t.Errorf("EPD.Comment() = %q, want %q", epd.Comment(), "main line; mostly")
+	}
+	if n, ok := epd.Depth(); !ok || n != 12 {
+		t.Errorf("EPD.Depth() = %d, %v, want 12", n, ok)
+	}
+	if n, ok := epd.Eval(); !ok || n != -15 {
+		t.Errorf("EPD.Eval() = %d, %v, want -15", n, ok)
+	}
+	if am, err := epd.AvoidMoves(); err != nil || len(am) != 2 {
+		t.Errorf("EPD.AvoidMoves() = %v, %v, want 2 moves", am, err)
+	}
+	if pv, err := epd.PV(); err != nil || len(pv) != 3 || pv[2].UCI() != "b5a4" {
+		t.Errorf("EPD.PV() = %v, %v, want b5 a6 a4", pv, err)
+	}
+	if len(epd.Board.history) != 0 {
+		t.Errorf("EPD.PV() changed the board")
+	}
+
+	epd.Set("bm", "Bc4")
+	epd.Set("acd", "14")
+	if got, _ := epd.Get("acd"); len(got) != 1 || got[0] != "14" || !strings.HasSuffix(epd.String(), "fmvn 3; bm Bc4;") {
+		t.Errorf("EPD.Set() gives %q", epd.String())
+	}
+
+	for _, bad := range []string{
+		"8/8/8/8 w - -",
+		"8/8/8/8/8/8/8/8 w - - bm Qg6",
+		`8/8/8/8/8/8/8/8 w - - id "WAC;`,
+		"8/8/8/8/8/8/8/8 w - - acd deep;",
+	} {
+		if _, err := ParseEPD(bad); err == nil {
+			t.Errorf("ParseEPD(%q) gives no error", bad)
+		}
+	}
+
+	records, err := ReadEPD(strings.NewReader(wac + "\n\n" + analysed + "\n"))
+	if err != nil || len(records) != 2 {
+		t.Errorf("ReadEPD() = %d records, %v, want 2", len(records), err)
+	}
+	if _, err := ReadEPD(strings.NewReader(wac + "\nnot epd\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("ReadEPD() gives error %v, want one for line 2", err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	if got := Evaluate(StartingPosition()); got != 0 {
+		t.Errorf("Evaluate(starting position) = %d, want 0", got)
+	}
+
+	white, _ := NewBoard("4k3/8/8/3q4/8/8/8/3RK3 w - - 0 1")
+	black, _ := NewBoard("3rk3/8/8/8/3Q4/8/8/4K3 b - - 0 1")
+	if a, b := Evaluate(white), Evaluate(black); a != b || a >= 0 {
+		t.Errorf("Evaluate() of mirrored positions = %d and %d, want the same negative score", a, b)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		score Score // 0 to skip
+	}{
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a8", MateScore - 1},
+		{"4k3/8/8/3q4/8/8/8/3RK3 w - - 0 1", "d1d5", 0},
+		{"2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - 0 1", "g3g6", MateScore - 3},
+		{"7k/5K2/6Q1/8/8/8/8/8 b - - 0 1", "", 0}, // stalemate
+	}
+
+	var s Searcher
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		result := s.Search(board, SearchLimits{Depth: 4})
+		if result.Move.UCI() != test.move || (test.score != 0 && result.Score != test.score) {
+			t.Errorf("Searcher.Search(%q) = %s with score %d, want %s", test.fen, result.Move.UCI(), result.Score, test.move)
+		}
+		if result.Move.IsValid() && (len(result.PV) == 0 || result.PV[0] != result.Move) {
+			t.Errorf("Searcher.Search(%q) PV = %v, want it to start with %s", test.fen, result.PV, result.Move.UCI())
+		}
+		if board.String() != test.fen || len(board.history) != 0 {
+			t.Errorf("Searcher.Search(%q) changed the board to %q", test.fen, board.String())
+		}
+	}
+
+	board := StartingPosition()
+	if result := s.Search(board, SearchLimits{Nodes: 2000}); !result.Move.IsValid() || result.Depth < 1 || result.Nodes > 4000 {
+		t.Errorf("Searcher.Search() with a node limit = %+v", result)
+	}
+	if result := s.Search(board, SearchLimits{Depth: 3}); result.Depth != 3 {
+		t.Errorf("Searcher.Search() with a depth limit reached depth %d, want 3", result.Depth)
+	}
+}
+
+func TestHalfmoveClock(t *testing.T) {
+	board := StartingPosition()
+	for _, step := range []struct {
+		san   string
+		clock int
+	}{{"Nf3", 1}, {"Nc6", 2}, {"e4", 0}, {"d5", 0}, {"Bb5", 1}, {"dxe4", 0}} {
+		if board.Play(step.san); board.HalfmoveClock != step.clock {
+			t.Errorf("halfmove clock after %s = %d, want %d", step.san, board.HalfmoveClock, step.clock)
+		}
+	}
+
+	// the clock counts plies, up to 150 for the 75 move rule
+	if _, err := NewBoard("8/8/4k3/8/8/3QK3/8/8 w - - 150 80"); err != nil {
+		t.Errorf("NewBoard() with a halfmove clock of 150 gives error, %v", err)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		fen    string
+		moves  []string
+		want   Outcome
+		result string
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []string{"f3", "e5", "g4", "Qh4"}, Outcome{Black, EndCheckmate}, "0-1"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []string{"e4", "e5"}, Outcome{}, "*"},
+		{"7k/5K2/6Q1/8/8/8/8/8 b - - 0 1", nil, Outcome{Reason: EndStalemate}, "1/2-1/2"},
+		{"8/8/4k3/8/8/3BK3/8/8 w - - 0 1", nil, Outcome{Reason: EndInsufficientMaterial}, "1/2-1/2"},
+		{"8/8/4k3/1b6/8/3BK3/8/8 w - - 0 1", nil, Outcome{Reason: EndInsufficientMaterial}, "1/2-1/2"},
+		{"8/8/4k3/2b5/8/3BK3/8/8 w - - 0 1", nil, Outcome{}, "*"},
+		{"8/8/4k3/2n5/8/3BK3/8/8 w - - 0 1", nil, Outcome{}, "*"},
+		{"8/8/4k3/8/8/3RK3/8/8 w - - 99 80", []string{"Rd1"}, Outcome{Reason: EndFiftyMoves}, "1/2-1/2"},
+		{"4k3/R7/4K3/8/8/8/8/8 w - - 99 80", []string{"Ra8#"}, Outcome{White, EndCheckmate}, "1-0"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []string{"Nf3", "Nf6", "Ng1", "Ng8", "Nf3", "Nf6", "Ng1"}, Outcome{}, "*"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []string{"Nf3", "Nf6", "Ng1", "Ng8", "Nf3", "Nf6", "Ng1", "Ng8"}, Outcome{Reason: EndRepetition}, "1/2-1/2"},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		for _, san := range test.moves {
+			if _, err := board.Play(san); err != nil {
+				t.Fatalf("Board.Play(%q) gives error, %v", san, err)
+			}
+		}
+
+		if got := board.Outcome(); got != test.want || got.Result() != test.result {
+			t.Errorf("Board.Outcome() after %q %v = %+v (%s), want %+v (%s)", test.fen, test.moves, got, got.Result(), test.want, test.result)
+		}
+	}
+}
+
+func TestNewUCIMove(t *testing.T) {
+	board, _ := NewBoard("r3k2r/1P6/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	tests := []struct {
+		uci  string
+		want string
+		err  error
+	}{
+		{"e1g1", "O-O", nil},
+		{"b7a8q", "b7xa8=Q", nil},
+		{"b7b8n", "b7b8=N", nil},
+		{"b7b8", "", ErrIllegalMove},
+		{"e1e3", "", ErrIllegalMove},
+		{"b7b8k", "", ErrInvalidNotation},
+		{"e9e1", "", ErrInvalidNotation},
+	}
+
+	for _, test := range tests {
+		move, err := NewUCIMove(test.uci, board)
+		if !errors.Is(err, test.err) || move.String() != test.want {
+			t.Errorf("NewUCIMove(%q) = %q, %v, want %q, %v", test.uci, move.String(), err, test.want, test.err)
+		}
+	}
+}
+
+// Stands in for a UCI engine when TestUCIEngine runs the test binary
+func TestUCIHelperProcess(t *testing.T) {
+	if os.Args[len(os.Args)-1] != "uci-helper" {
+		return
+	}
+
+	position, multiPV, hung := "", "1", false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name Helper 1.0")
+			fmt.Println("id author The Tests")
+			fmt.Println("option name Hash type spin default 16 min 1 max 1024")
+			fmt.Println("option name Clear Hash type button")
+			fmt.Println("option name Style type combo default Normal var Solid var Normal var Risky")
+			fmt.Println("option name Book File type string default <empty>")
+			fmt.Println("option name MultiPV type spin default 1 min 1 max 500")
+			fmt.Println("uciok")
+		case "isready":
+			if !hung {
+				fmt.Println("readyok")
+			}
+		case "setoption":
+			hung = strings.HasSuffix(scanner.Text(), "value hang")
+			if len(fields) == 5 && fields[2] == "MultiPV" {
+				multiPV = fields[4]
+			}
+		case "position":
+			position = scanner.Text()
+		case "go":
+			fmt.Println("info string " + position)
+			if multiPV != "1" {
+				fmt.Println("info string multipv " + multiPV)
+			}
+			if fields[len(fields)-1] == "infinite" || fields[1] == "ponder" {
+				continue // until stop or ponderhit
+			}
+			fmt.Println("info depth 1 seldepth 2 multipv 1 score cp 31 nodes 20 nps 2000 time 10 pv e2e4")
+			fmt.Println("info depth 4 multipv 2 score cp 12 pv d2d4 d7d5")
+			fmt.Println("info depth 4 seldepth 6 multipv 1 score mate 2 lowerbound nodes 500 time 250 pv e2e4 e7e5 d1h5 a7a6 currmove e2e4 hashfull 3")
+			fmt.Println("bestmove e2e4 ponder e7e5")
+		case "stop":
+			fmt.Println("bestmove d2d4")
+		case "ponderhit":
+			fmt.Println("bestmove g1f3")
+		case "quit":
+			os.Exit(0)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestUCIEngine(t *testing.T) {
+	engine, err := StartUCIEngine(os.Args[0], "-test.run=^TestUCIHelperProcess$", "--", "uci-helper")
+	if err != nil {
+		t.Fatalf("StartUCIEngine() gives error, %v", err)
+	}
+	defer engine.Close()
+
+	if engine.Name != "Helper 1.0" || engine.Author != "The Tests" || len(engine.Options) != 5 {
+		t.Errorf("StartUCIEngine() = %q by %q with %d options, want Helper 1.0 by The Tests with 5", engine.Name, engine.Author, len(engine.Options))
+	}
+	if opt := engine.Options["style"]; opt.Name != "Style" || opt.Default != "Normal" || len(opt.Vars) != 3 {
+		t.Errorf("Options[\"style\"] = %+v", opt)
+	}
+	if opt := engine.Options["book file"]; opt.Name != "Book File" || opt.Type != "string" || opt.Default != "" {
+		t.Errorf("Options[\"book file\"] = %+v", opt)
+	}
+
+	for _, opt := range []struct {
+		name, value string
+		ok          bool
+	}{
+		{"hash", "64", true}, {"Hash", "0", false}, {"Clear Hash", "", true},
+		{"Style", "risky", true}, {"Style", "Wild", false}, {"Threads", "2", false},
+	} {
+		if err := engine.SetOption(opt.name, opt.value); (err == nil) != opt.ok {
+			t.Errorf("UCIEngine.SetOption(%q, %q) gives error %v", opt.name, opt.value, err)
+		}
+	}
+	if err := engine.NewGame(); err != nil {
+		t.Fatalf("UCIEngine.NewGame() gives error, %v", err)
+	}
+
+	board := StartingPosition()
+	if err := engine.SetPosition(board); err != nil {
+		t.Fatalf("UCIEngine.SetPosition() gives error, %v", err)
+	}
+	ft := newFakeTime()
+	engine.Now = ft.Now
+	var infos []SearchInfo
+	result, err := engine.Go(SearchLimits{Depth: 4}, func(info SearchInfo) {
+		infos = append(infos, info)
+		ft.advance(100 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("UCIEngine.Go() gives error, %v", err)
+	}
+
+	if result.Move.UCI() != "e2e4" || result.Ponder.UCI() != "e7e5" || result.Score != MateScore-3 || result.Depth != 4 || len(result.PV) != 4 || result.Nodes != 500 || len(result.Lines) != 2 || result.Lines[1].Score != 12 || result.Time != 400*time.Millisecond {
+		t.Errorf("UCIEngine.Go() = %+v", result)
+	}
+	if len(infos) != 4 || infos[0].String != "position startpos" {
+		t.Fatalf("UCIEngine.Go() info = %+v", infos)
+	}
+	if got := infos[1]; got.Depth != 1 || got.SelDepth != 2 || got.Score != 31 || got.NPS != 2000 || got.Time != 10*time.Millisecond || len(got.PV) != 1 {
+		t.Errorf("UCIEngine.Go() info = %+v", got)
+	}
+	if got := infos[2]; got.MultiPV != 2 || got.PV[1].UCI() != "d7d5" {
+		t.Errorf("UCIEngine.Go() info = %+v", got)
+	}
+	if got := infos[3]; !got.LowerBound || got.SelDepth != 6 || got.PV[3].UCI() != "a7a6" {
+		t.Errorf("UCIEngine.Go() info = %+v", got)
+	}
+
+	// a multi-PV search sets the engine's MultiPV, and the next search sets
+	// it back, which the helper reports when it isn't 1
+	multiPV := func(limits SearchLimits) string {
+		got := ""
+		_, err := engine.Go(limits, func(info SearchInfo) {
+			if strings.HasPrefix(info.String, "multipv ") {
+				got = info.String
+			}
+		})
+		if err != nil {
+			t.Errorf("UCIEngine.Go(%+v) gives error, %v", limits, err)
+		}
+		return got
+	}
+	if got := multiPV(SearchLimits{Depth: 4, MultiPV: 3}); got != "multipv 3" {
+		t.Errorf("UCIEngine.Go() of 3 lines left the engine at %q, want multipv 3", got)
+	}
+	if got := multiPV(SearchLimits{Depth: 4}); got != "" {
+		t.Errorf("UCIEngine.Go() of one line after 3 left the engine at %q", got)
+	}
+
+	board, _ = NewBoard("4k3/8/8/8/8/8/3P4/4K3 w - - 0 1")
+	board.Play("d4")
+	board.Play("Kf7")
+	engine.SetPosition(board)
+	// the helper echoes the position once it's searching, so it's stopped then
+	result, err = engine.Go(SearchLimits{}, func(info SearchInfo) {
+		if want := "position fen 4k3/8/8/8/8/8/3P4/4K3 w - - 0 1 moves d2d4 e8f7"; info.String != want {
+			t.Errorf("UCIEngine.SetPosition() sent %q, want %q", info.String, want)
+		}
+		engine.Stop()
+	})
+	if err == nil || result.Move.IsValid() {
+		t.Errorf("UCIEngine.Go() of an illegal best move = %v, %v, want an error", result.Move, err)
+	}
+
+	engine.SetPosition(StartingPosition())
+	ponderHit := func(SearchInfo) { engine.PonderHit() }
+	if result, err = engine.Go(SearchLimits{Ponder: true, MoveTime: time.Second}, ponderHit); err != nil || result.Move.UCI() != "g1f3" {
+		t.Errorf("UCIEngine.Go() pondering = %v, %v, want g1f3 after ponderhit", result.Move, err)
+	}
+
+	// an engine that stops answering times out, here as soon as it's waited on
+	engine.After = func(time.Duration) <-chan time.Time {
+		expired := make(chan time.Time, 1)
+		expired <- time.Time{}
+		return expired
+	}
+	engine.SetOption("Book File", "hang")
+	if err := engine.IsReady(); err == nil {
+		t.Errorf("UCIEngine.IsReady() of a hung engine gives no error")
+	}
+	engine.SetOption("Book File", "")
+	engine.After = nil
+
+	for _, test := range []struct {
+		limits SearchLimits
+		want   string
+	}{
+		{SearchLimits{}, "go infinite"},
+		{SearchLimits{Depth: 5, Nodes: 1000}, "go depth 5 nodes 1000"},
+		{SearchLimits{MoveTime: 1500 * time.Millisecond}, "go movetime 1500"},
+		{SearchLimits{Ponder: true, MoveTime: time.Second}, "go ponder movetime 1000"},
+		{SearchLimits{Infinite: true, Depth: 5}, "go infinite"},
+	} {
+		if got := uciGoCommand(test.limits); got != test.want {
+			t.Errorf("uciGoCommand(%+v) = %q, want %q", test.limits, got, test.want)
+		}
+	}
+}
+
+func TestSAN(t *testing.T) {
+	tests := []struct {
+		fen  string
+		uci  string
+		want string
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "g1f3", "Nf3"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e2e4", "e4"},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", "exd6"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b kq - 0 1", "e8g8", "O-O"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "a1d1", "Rad1"},
+		{"4k3/8/8/8/8/8/8/R3K2R w - - 0 1", "a1a8", "Ra8+"},
+		{"4k3/8/8/8/R7/8/8/R3K3 w - - 0 1", "a1a2", "R1a2"},
+		{"7k/2N5/8/8/8/2N1N3/8/4K3 w - - 0 1", "c3d5", "Nc3d5"},
+		{"7k/2N5/8/8/8/2N1N3/8/4K3 w - - 0 1", "e3d5", "Ned5"},
+		{"4r2k/8/8/8/8/8/4N3/1N2K3 w - - 0 1", "b1c3", "Nc3"}, // the other knight is pinned
+		{"4k3/4r3/8/8/8/8/4R3/R3K3 w - - 0 1", "a1a2", "Ra2"}, // and the other rook
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8q", "b8=Q+"},
+		{"r3k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7a8n", "bxa8=N"},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a8", "Ra8#"},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a9", ""},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "g1g3", ""},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		move := Move{From: NewCoord(test.uci[:2]), To: NewCoord(test.uci[2:4])}
+		if len(test.uci) == 5 {
+			move.PromotesTo = NewPieceName(strings.ToUpper(test.uci[4:]))
+		}
+
+		got := board.SAN(move)
+		if got != test.want {
+			t.Errorf("Board.SAN(%s) in %q = %q, want %q", test.uci, test.fen, got, test.want)
+		}
+		if got == "" {
+			continue
+		}
+		if parsed, err := NewMove(got, board); err != nil || !parsed.Matches(move) {
+			t.Errorf("NewMove(%q) = %v, %v, want %s", got, parsed, err, test.uci)
+		}
+	}
+
+	board, _ := NewBoard("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1")
+	replay := board.Clone()
+	var moves []Move
+	for _, san := range []string{"e5", "Nf3", "Nc6", "Bb5"} {
+		move, _ := replay.Play(san)
+		moves = append(moves, move)
+	}
+	if got, want := board.SANLine(moves), "1... e5 2. Nf3 Nc6 3. Bb5"; got != want {
+		t.Errorf("Board.SANLine() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchMultiPV(t *testing.T) {
+	// taking the queen mates
+	board, _ := NewBoard("1q4k1/5ppp/8/8/8/8/5PPP/1R4K1 w - - 0 1")
+
+	var s Searcher
+	result := s.Search(board, SearchLimits{Depth: 3, MultiPV: 3})
+	if len(result.Lines) != 3 {
+		t.Fatalf("Searcher.Search() gives %d lines, want 3", len(result.Lines))
+	}
+	if got := board.SAN(result.Lines[0].PV[0]); got != "Rxb8#" || result.Move != result.Lines[0].PV[0] {
+		t.Errorf("Searcher.Search() best line starts with %s, want Rxb8#", got)
+	}
+	for i := 1; i < len(result.Lines); i++ {
+		if result.Lines[i].Score > result.Lines[i-1].Score {
+			t.Errorf("line %d scores %d, more than line %d's %d", i+1, result.Lines[i].Score, i, result.Lines[i-1].Score)
+		}
+		if result.Lines[i].PV[0] == result.Lines[0].PV[0] {
+			t.Errorf("line %d repeats the best move", i+1)
+		}
+	}
+
+	board, _ = NewBoard("7k/8/6K1/8/8/8/8/6R1 w - - 0 1")
+	if result := s.Search(board, SearchLimits{Depth: 2, MultiPV: 100}); len(result.Lines) != len(board.Moves()) {
+		t.Errorf("Searcher.Search() gives %d lines, want one per legal move, %d", len(result.Lines), len(board.Moves()))
+	}
+}
+
+func TestSearchPonder(t *testing.T) {
+	board := StartingPosition()
+
+	// an infinite search goes past its depth until stopped, here once it
+	// reports its third iteration
+	var s Searcher
+	var infos []SearchInfo
+	s.Info = func(info SearchInfo) {
+		infos = append(infos, info)
+		if info.Depth == 3 && info.PV != nil {
+			s.Stop()
+		}
+	}
+	result := s.Search(board, SearchLimits{Depth: 1, Infinite: true})
+	if result.Depth != 3 || !result.Move.IsValid() {
+		t.Errorf("Searcher.Search() stopped = %+v, want a move from depth 3", result)
+	}
+	depth := 0
+	for _, info := range infos {
+		if info.PV == nil {
+			continue // a periodic node count
+		}
+		if depth++; info.Depth != depth || info.MultiPV != 1 || info.Nodes == 0 {
+			t.Errorf("Searcher.Info got %+v, want a line for iteration %d", info, depth)
+		}
+	}
+	if depth != result.Depth {
+		t.Errorf("Searcher.Info got %d lines, want one per iteration, %d", depth, result.Depth)
+	}
+
+	// every reading of the time moves it on a second, which would end a 3s
+	// search in its second iteration, but pondering ignores the time until
+	// the ponder hit, sent here at depth 4
+	ft := newFakeTime()
+	s.Now = func() time.Time {
+		ft.advance(time.Second)
+		return ft.Now()
+	}
+	hit := false
+	s.Info = func(info SearchInfo) {
+		if info.Depth == 4 && info.PV != nil && !hit {
+			hit = true
+			s.PonderHit()
+		}
+	}
+	result = s.Search(board, SearchLimits{MoveTime: 3 * time.Second, Ponder: true})
+	if !hit || result.Depth < 4 || !result.Move.IsValid() {
+		t.Errorf("Searcher.Search() pondering = %s at depth %d, want a move from depth 4 on after PonderHit", result.Move.UCI(), result.Depth)
+	}
+}
+
+func TestSearchPrepare(t *testing.T) {
+	board := StartingPosition()
+
+	// a Stop or PonderHit sent after Prepare but before Search starts still
+	// counts, where Search alone would wait for one forever
+	var s Searcher
+	limits := SearchLimits{Depth: 2, Infinite: true}
+	s.Prepare(limits)
+	s.Stop()
+	if result := s.Search(board, limits); !result.Move.IsValid() || result.Depth != 1 {
+		t.Errorf("Searcher.Search() stopped before it started = %s at depth %d, want a move at depth 1", result.Move.UCI(), result.Depth)
+	}
+
+	limits = SearchLimits{Depth: 2, Ponder: true}
+	s.Prepare(limits)
+	s.PonderHit()
+	if result := s.Search(board, limits); !result.Move.IsValid() || result.Depth != 2 {
+		t.Errorf("Searcher.Search() after an early PonderHit = %s at depth %d, want a move at depth 2", result.Move.UCI(), result.Depth)
+	}
+
+	// without Prepare, a Stop from an earlier search doesn't carry over
+	s.Stop()
+	if result := s.Search(board, SearchLimits{Depth: 3}); result.Depth != 3 {
+		t.Errorf("Searcher.Search() after a stale Stop reached depth %d, want 3", result.Depth)
+	}
+}
+
+func TestSearchFiftyMoves(t *testing.T) {
+	tests := []struct {
+		fen  string
+		want string
+	}{
+		{"8/8/4k3/8/8/3QK3/8/8 w - - 99 80", "+0.00"}, // any move draws
+		{"4k3/R7/4K3/8/8/8/8/8 w - - 99 80", "#1"},    // but mate comes first
+	}
+
+	var s Searcher
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		if got := s.Search(board, SearchLimits{Depth: 3}).Score; got.String() != test.want {
+			t.Errorf("Searcher.Search() on %q = %s, want %s", test.fen, got, test.want)
+		}
+	}
+	board, _ := NewBoard("8/8/4k3/8/8/3QK3/8/8 w - - 90 80")
+	if got := s.Search(board, SearchLimits{Depth: 3}).Score; got < 500 {
+		t.Errorf("Searcher.Search() with the draw out of reach = %s, want a queen up", got)
+	}
+}
+
+func TestSearchStopEarly(t *testing.T) {
+	// stopped at the first evaluation, the search still finishes depth 1
+	var s Searcher
+	s.Eval = func(board *Board) int {
+		s.Stop()
+		return Evaluate(board)
+	}
+	result := s.Search(StartingPosition(), SearchLimits{Depth: 5})
+	if !result.Move.IsValid() || result.Depth != 1 {
+		t.Errorf("Searcher.Search() stopped in its first iteration = %s at depth %d, want a move at depth 1", result.Move.UCI(), result.Depth)
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		score     Score
+		str, uci  string
+		mateMoves int
+	}{
+		{0, "+0.00", "cp 0", 0},
+		{35, "+0.35", "cp 35", 0},
+		{-120, "-1.20", "cp -120", 0},
+		{MateScore - 1, "#1", "mate 1", 1},
+		{MateScore - 3, "#2", "mate 2", 2},
+		{2 - MateScore, "#-1", "mate -1", -1},
+		{-MateScore, "#0", "mate 0", 0},
+	}
+
+	for _, test := range tests {
+		moves, ok := test.score.Mate()
+		if test.score.String() != test.str || test.score.UCI() != test.uci || moves != test.mateMoves || ok != test.score.IsMate() {
+			t.Errorf("Score(%d) = %s, %s, mate %d %t, want %s, %s, mate %d", int(test.score), test.score, test.score.UCI(), moves, ok, test.str, test.uci, test.mateMoves)
+		}
+		if ok && MateIn(moves) != test.score {
+			t.Errorf("MateIn(%d) = %d, want %d", moves, int(MateIn(moves)), int(test.score))
+		}
+	}
+
+	// mated an odd number of plies away, as scored from the other side's view
+	if moves, ok := Score(1 - MateScore).Mate(); moves != -1 || !ok || Score(1-MateScore).String() != "#-1" {
+		t.Errorf("Score(%d).Mate() = %d, %t, want -1, true", 1-MateScore, moves, ok)
+	}
+}
+
+func TestFindMate(t *testing.T) {
+	tests := []struct {
+		fen       string
+		moves     int
+		solutions []string
+		pv        string
+	}{
+		{"6k1/5ppp/8/8/8/8/1R6/R5K1 w - - 0 1", 1, []string{"a1a8", "b2b8"}, "1. Ra8#"},
+		{"2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - 0 1", 2, []string{"g3g6"}, ""},
+		{"r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", 3, []string{"f8c5"}, "1... Bc5+ 2. Kxc5 Qb6+ 3. Kd5 Qd6#"},
+		{"7k/8/6Q1/8/8/8/8/K7 b - - 0 1", 0, nil, ""}, // stalemate
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0, nil, ""},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		solution, ok := FindMate(board, 3)
+		if ok != (test.moves > 0) || solution.Moves != test.moves || len(solution.Solutions) != len(test.solutions) {
+			t.Errorf("FindMate(%q) = mate in %d with %d solutions, want mate in %d with %d", test.fen, solution.Moves, len(solution.Solutions), test.moves, len(test.solutions))
+			continue
+		}
+		for i, move := range solution.Solutions {
+			if move.UCI() != test.solutions[i] {
+				t.Errorf("FindMate(%q) solution %d = %s, want %s", test.fen, i, move.UCI(), test.solutions[i])
+			}
+		}
+		if solution.Unique() != (len(test.solutions) == 1) {
+			t.Errorf("FindMate(%q).Unique() = %t", test.fen, solution.Unique())
+		}
+		if ok && len(solution.PV) != 2*test.moves-1 {
+			t.Errorf("FindMate(%q) PV has %d plies, want %d", test.fen, len(solution.PV), 2*test.moves-1)
+		}
+		if test.pv != "" && board.SANLine(solution.PV) != test.pv {
+			t.Errorf("FindMate(%q) PV = %q, want %q", test.fen, board.SANLine(solution.PV), test.pv)
+		}
+		if ok && solution.Score() != MateIn(test.moves) {
+			t.Errorf("FindMate(%q).Score() = %s, want %s", test.fen, solution.Score(), MateIn(test.moves))
+		}
+		if board.String() != test.fen {
+			t.Errorf("FindMate(%q) changed the board to %q", test.fen, board.String())
+		}
+	}
+}
+
+func TestAttacks(t *testing.T) {
+	board, _ := NewBoard("4k3/8/8/3n4/8/8/8/3RK3 w - - 0 1")
+	if got := board.Attackers(NewCoord("d5"), White); len(got) != 1 || got[0] != NewCoord("d1") {
+		t.Errorf("Attackers(d5, White) = %v, want [d1]", got)
+	}
+	if got := board.Attackers(NewCoord("e2"), Black); len(got) != 0 {
+		t.Errorf("Attackers(e2, Black) = %v, want none", got)
+	}
+
+	tests := []struct {
+		square string
+		count  int
+	}{
+		{"d1", 8}, // d2 to d5, c1 to a1 and the king it defends
+		{"d5", 8},
+		{"e1", 5},
+		{"e4", 0},
+	}
+	for _, test := range tests {
+		if got := board.Attacks(NewCoord(test.square)); len(got) != test.count {
+			t.Errorf("Attacks(%s) = %v, want %d squares", test.square, got, test.count)
+		}
+	}
+}
+
+func TestMotifs(t *testing.T) {
+	tests := []struct {
+		fen, move string // the motifs of the move if it's given, else of the position
+		want      []string
+	}{
+		{"r3k3/8/8/1N6/8/8/8/4K3 w - - 0 1", "b5c7", []string{"fork c7 e8 a8", "hanging piece a8"}},
+		{"4k3/4r3/8/8/8/8/8/4RK2 w - - 0 1", "", []string{"pin e1 e7 e8"}},
+		{"8/8/8/8/q2k3R/8/8/4K3 b - - 0 1", "", []string{"skewer h4 d4 a4"}},
+		{"4k3/8/8/8/4N3/8/8/4R1K1 w - - 0 1", "e4d6", []string{"double check e1 d6", "discovered attack e1 d6 e8"}},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "", []string{"back rank weakness g8"}},
+		{"4k3/8/8/3n4/8/8/8/3RK3 w - - 0 1", "", []string{"hanging piece d5"}},
+		{"1b1rk3/8/8/3n4/8/8/8/1R1RK3 w - - 0 1", "", []string{"pin d1 d5 d8", "overloaded defender d8 d5 b8"}},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "", nil},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e2e4", nil},
+	}
+
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		var motifs []Motif
+		if test.move == "" {
+			motifs = board.Motifs()
+		} else {
+			move, err := NewUCIMove(test.move, board)
+			if err != nil {
+				t.Fatalf("NewUCIMove(%q) error: %v", test.move, err)
+			}
+			motifs = board.MoveMotifs(move)
+		}
+
+		got := make([]string, 0, len(motifs))
+		for _, m := range motifs {
+			got = append(got, m.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("motifs of %q %s = %v, want %v", test.fen, test.move, got, test.want)
+		}
+	}
+}
+
+func TestPuzzleFinder(t *testing.T) {
+	finder := NewPuzzleFinder(SearchLimits{Depth: 4})
+
+	game, err := NewPGNReader(strings.NewReader("1. e4 e5 2. Bc4 Nc6 3. Qh5 Nf6 4. Qxf7# 1-0\n")).Next()
+	if err != nil {
+		t.Fatalf("reading the game: %v", err)
+	}
+	puzzles, err := finder.Find(game)
+	if err != nil {
+		t.Fatalf("Find() error: %v", err)
+	}
+	want := "r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4,h5f7,mate mateIn1 pin"
+	if len(puzzles) != 1 || puzzles[0].String() != want || puzzles[0].Ply != 5 || puzzles[0].Blunder.UCI() != "g8f6" {
+		t.Fatalf("Find() = %v, want [%s] after g8f6", puzzles, want)
+	}
+
+	tests := []struct {
+		fen      string
+		solution string // empty if there's no puzzle
+		tags     string
+	}{
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a8", "backRankWeakness mate mateIn1"},
+		{"6k1/5ppp/8/8/8/8/1R6/R5K1 w - - 0 1", "", ""}, // two mates in one
+		{"4k3/pp6/q7/1N6/8/8/PP6/R3K3 w - - 0 1", "b5c7 e8d7 c7a6", "fork hangingPiece"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "", ""},
+	}
+	for _, test := range tests {
+		board, _ := NewBoard(test.fen)
+		puzzle, ok := finder.Solve(board)
+		if ok != (test.solution != "") || (ok && (puzzle.UCI() != test.solution || strings.Join(puzzle.Tags, " ") != test.tags)) {
+			t.Errorf("Solve(%q) = %q %v, %t, want %q %v", test.fen, puzzle.UCI(), puzzle.Tags, ok, test.solution, test.tags)
+		}
+	}
+}
+
+func TestGameWriteTo(t *testing.T) {
+	game, err := NewPGNReader(strings.NewReader(testPGN)).Next()
+	if err != nil {
+		t.Fatalf("PGNReader.Next() gives error, %v", err)
+	}
+	if len(game.Annotations) != 3 || game.Annotations[2].Comment != "a comment" || game.Annotations[5].NAG != 1 || game.Annotations[8].NAG != 1 {
+		t.Errorf("PGNReader.Next() annotations = %v, want a comment after move 3 and ! after moves 6 and 9", game.Annotations)
+	}
+
+	game.Tags["Annotator"] = "test"
+	game.Annotations[9] = Annotation{NAG: 14, Comment: "slightly better for white, with {braces}"}
+	want := `[Event "Test"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "A \"the first\""]
+[Black "B"]
+[Result "1-0"]
+[Annotator "test"]
+
+1. e4 e5 2. Nf3 {a comment} 2... Nc6 3. Bb5 a6! 4. Ba4 Nf6 5. O-O! Be7 $14
+{slightly better for white, with {braces)} 1-0
+
+`
+	buf := bytes.Buffer{}
+	if _, err := game.WriteTo(&buf); err != nil || buf.String() != want {
+		t.Fatalf("Game.WriteTo() = %q, %v, want %q", buf.String(), err, want)
+	}
+
+	read, err := NewPGNReader(&buf).Next()
+	if err != nil || len(read.Moves) != len(game.Moves) || len(read.Annotations) != 4 || read.Annotations[9].NAG != 14 || read.Tags["White"] != `A "the first"` {
+		t.Errorf("reading the written game = %v, %v, want the game back", read, err)
+	}
+}
+
+func TestAnnotator(t *testing.T) {
+	game, _ := NewPGNReader(strings.NewReader("1. e4 e5 2. Bc4 Nc6 3. Qh5 Nf6 4. Qxf7# 1-0\n")).Next()
+	annotator := NewAnnotator(SearchLimits{Depth: 4})
+
+	analysis, err := annotator.Analyze(game)
+	if err != nil || len(analysis) != len(game.Moves) {
+		t.Fatalf("Annotator.Analyze() = %d moves, %v, want %d", len(analysis), err, len(game.Moves))
+	}
+	if m := analysis[5]; m.NAG != 4 || m.Loss < annotator.Blunder || m.After != 1-MateScore {
+		t.Errorf("Analyze() of Nf6 = loss %d, NAG %d, after %s, want a blunder into mate", m.Loss, m.NAG, m.After)
+	}
+	if m := analysis[6]; m.Loss != 0 || m.NAG != 0 {
+		t.Errorf("Analyze() of Qxf7# = loss %d, NAG %d, want the best move", m.Loss, m.NAG)
+	}
+
+	// the game's own comment is kept, ahead of the annotator's
+	game.Annotations = map[int]Annotation{5: {Comment: "defending f7?"}}
+	annotated, err := annotator.Annotate(game)
+	if err != nil {
+		t.Fatalf("Annotator.Annotate() error: %v", err)
+	}
+	note := annotated.Annotations[5]
+	if note.NAG != 4 || !strings.HasPrefix(note.Comment, "defending f7? Blunder (") || !strings.Contains(note.Comment, "Better is 3... ") {
+		t.Errorf("Annotate() of Nf6 = %+v, want ?? and a better line after the comment", note)
+	}
+	if len(game.Annotations) != 1 || game.Annotations[5] != (Annotation{Comment: "defending f7?"}) {
+		t.Errorf("Annotate() changed the original game's annotations to %v", game.Annotations)
+	}
+
+	// with no line found, there's no better move to give
+	board, _ := game.StartingPosition()
+	if got := annotator.comment(board, MoveAnalysis{Score: 40, After: -90, NAG: 2}); got != "Mistake (+0.40 -> -0.90)" {
+		t.Errorf("Annotator.comment() with no best line = %q", got)
+	}
+	annotated.Tags["Event"], annotated.Moves[0] = "Annotated", Move{}
+	if game.Tags["Event"] == "Annotated" || !game.Moves[0].IsValid() {
+		t.Errorf("Annotate() returned a game sharing tags or moves with the original")
+	}
+}
+
+func TestNewGame(t *testing.T) {
+	board := StartingPosition()
+	for _, san := range []string{"e4", "e5", "Nf3"} {
+		board.Play(san)
+	}
+	game := NewGame(board)
+	if len(game.Moves) != 3 || game.Moves[2].UCI() != "g1f3" || game.Tags["FEN"] != "" || game.Result != "*" {
+		t.Errorf("NewGame() = %v, want e4 e5 Nf3 from the starting position", game)
+	}
+	if final, err := game.Board(); err != nil || final.String() != board.String() {
+		t.Errorf("NewGame().Board() = %v, %v, want %s", final, err, board)
+	}
+
+	board, _ = NewBoard("6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1")
+	board.Play("Ra8")
+	if game := NewGame(board); game.Tags["FEN"] != "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1" || game.Result != "1-0" {
+		t.Errorf("NewGame() = %v, want the FEN tag and 1-0", game)
+	}
+}
+
+func TestGameStats(t *testing.T) {
+	phases := []struct {
+		fen   string
+		phase GamePhase
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", PhaseOpening},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 20", PhaseMiddlegame},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 5", PhaseEndgame},
+	}
+	for _, test := range phases {
+		if board, _ := NewBoard(test.fen); board.Phase() != test.phase {
+			t.Errorf("Board(%q).Phase() = %s, want %s", test.fen, board.Phase(), test.phase)
+		}
+	}
+
+	board := StartingPosition()
+	for _, san := range []string{"e4", "e5", "Bc4", "Nc6", "Qh5", "Nf6", "Qxf7#"} {
+		board.Play(san)
+	}
+	white, black, err := NewAnnotator(SearchLimits{Depth: 4}).Stats(board)
+	if err != nil {
+		t.Fatalf("Annotator.Stats() error: %v", err)
+	}
+	if white.Moves != 4 || black.Moves != 3 {
+		t.Errorf("Stats() moves = %d and %d, want 4 and 3", white.Moves, black.Moves)
+	}
+	if black.Blunders != 1 || black.PhaseBlunders[PhaseOpening] != 1 || white.Blunders != 0 {
+		t.Errorf("Stats() blunders = %d for white and %d (%v) for black, want black's one in the opening", white.Blunders, black.Blunders, black.PhaseBlunders)
+	}
+	if black.ACPL <= white.ACPL || black.Accuracy >= white.Accuracy || white.Accuracy > 100 || black.Accuracy < 0 {
+		t.Errorf("Stats() = ACPL %.1f, accuracy %.1f%% for white and %.1f, %.1f%% for black, want black worse", white.ACPL, white.Accuracy, black.ACPL, black.Accuracy)
+	}
+}
+
+// A time source for clocks that only moves when told to
+// A time source for clocks and engines that only moves on when told to
+type fakeTime struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeTime() *fakeTime {
+	return &fakeTime{now: time.Unix(0, 0)}
+}
+
+func (f *fakeTime) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After fires once the time has been advanced by d
+func (f *fakeTime) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+	} else {
+		f.timers = append(f.timers, fakeTimer{f.now.Add(d), c})
+	}
+	return c
+}
+
+func (f *fakeTime) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.at.After(f.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- f.now
+		}
+	}
+	f.timers = pending
+}
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		tc     string
+		stages []TimeStage
+	}{
+		{"300", []TimeStage{{0, 5 * time.Minute, 0}}},
+		{"180+2", []TimeStage{{0, 3 * time.Minute, 2 * time.Second}}},
+		{"40/5400+30:1800+30", []TimeStage{{40, 90 * time.Minute, 30 * time.Second}, {0, 30 * time.Minute, 30 * time.Second}}},
+		{"0.5+0.1", []TimeStage{{0, 500 * time.Millisecond, 100 * time.Millisecond}}},
+	}
+	for _, test := range tests {
+		control, err := ParseTimeControl(test.tc)
+		if err != nil || len(control.Stages) != len(test.stages) || control.String() != test.tc {
+			t.Errorf("ParseTimeControl(%q) = %v (%s), %v, want %v", test.tc, control.Stages, control, err, test.stages)
+			continue
+		}
+		for i, stage := range control.Stages {
+			if stage != test.stages[i] {
+				t.Errorf("ParseTimeControl(%q) stage %d = %v, want %v", test.tc, i, stage, test.stages[i])
+			}
+		}
+	}
+
+	for _, tc := range []string{"", "-", "?", "40/", "/300", "0", "300+x", "40/300:", "-5"} {
+		if _, err := ParseTimeControl(tc); err == nil {
+			t.Errorf("ParseTimeControl(%q) gives no error", tc)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	tests := []struct {
+		tc    string
+		typ   IncrementType
+		turns []time.Duration // alternating, white first
+		white time.Duration   // remaining after the turns
+		black time.Duration
+	}{
+		{"60", FischerIncrement, []time.Duration{10 * time.Second, 20 * time.Second}, 50 * time.Second, 40 * time.Second},
+		{"60+5", FischerIncrement, []time.Duration{10 * time.Second, 2 * time.Second}, 55 * time.Second, 63 * time.Second},
+		{"60+5", BronsteinDelay, []time.Duration{10 * time.Second, 2 * time.Second}, 55 * time.Second, 60 * time.Second},
+		{"60+5", SimpleDelay, []time.Duration{10 * time.Second, 2 * time.Second}, 55 * time.Second, 60 * time.Second},
+		{"2/60:30", FischerIncrement, []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second}, 60 * time.Second, 70 * time.Second},
+		{"1/60", FischerIncrement, []time.Duration{10 * time.Second, 0, 10 * time.Second}, 160 * time.Second, 120 * time.Second},
+	}
+
+	for _, test := range tests {
+		control, _ := ParseTimeControl(test.tc)
+		control.Type = test.typ
+		clock, ft := NewClock(control), newFakeTime()
+		clock.Now = ft.Now
+
+		clock.Start(White)
+		for _, d := range test.turns {
+			ft.advance(d)
+			if !clock.Press() {
+				t.Fatalf("%s %s: Clock.Press() flagged", test.tc, test.typ)
+			}
+		}
+		if w, b := clock.Remaining(White), clock.Remaining(Black); w != test.white || b != test.black {
+			t.Errorf("%s %s: Clock.Remaining() = %v and %v, want %v and %v", test.tc, test.typ, w, b, test.white, test.black)
+		}
+	}
+}
+
+func TestClockTimeout(t *testing.T) {
+	control, _ := ParseTimeControl("10")
+	clock, ft := NewClock(control), newFakeTime()
+	clock.Now = ft.Now
+
+	// an illegal first move doesn't start the clock
+	board := StartingPosition()
+	if err := clock.Play(board, Move{From: NewCoord("e2"), To: NewCoord("e5")}); err == nil || clock.Running().IsValid() {
+		t.Fatalf("Clock.Play(e2e5) = %v, running %v, want an error and the clock stopped", err, clock.Running())
+	}
+	ft.advance(5 * time.Second)
+
+	e4, _ := NewUCIMove("e2e4", board)
+	if err := clock.Play(board, e4); err != nil || clock.Running() != Black || clock.Moves(White) != 1 {
+		t.Fatalf("Clock.Play(e4) = %v, running %v, want black's clock running", err, clock.Running())
+	}
+
+	ft.advance(9 * time.Second)
+	if clock.Flagged().IsValid() || clock.Remaining(Black) != time.Second {
+		t.Errorf("Clock.Remaining(Black) = %v, flagged %v, want 1s", clock.Remaining(Black), clock.Flagged())
+	}
+
+	ft.advance(2 * time.Second)
+	e5, _ := NewUCIMove("e7e5", board)
+	if err := clock.Play(board, e5); !errors.Is(err, ErrTimeout) || board.SideToMove != Black {
+		t.Errorf("Clock.Play(e5) after the flag = %v, want ErrTimeout and the move taken back", err)
+	}
+	if outcome := clock.Outcome(board); outcome != (Outcome{White, EndTimeout}) || outcome.Result() != "1-0" {
+		t.Errorf("Clock.Outcome() = %+v, want white winning on time", outcome)
+	}
+
+	// a lone king can't win on time
+	board, _ = NewBoard("4k3/8/8/8/8/8/3PP3/4K3 b - - 0 1")
+	clock, ft = NewClock(control), newFakeTime()
+	clock.Now = ft.Now
+	clock.Start(White)
+	ft.advance(11 * time.Second)
+	if outcome := clock.Outcome(board); outcome != (Outcome{Reason: EndTimeout}) {
+		t.Errorf("Clock.Outcome() = %+v, want a draw on time", outcome)
+	}
+	if board.MatingMaterial(Black) || !board.MatingMaterial(White) {
+		t.Errorf("MatingMaterial() = %t for white and %t for black, want true and false", board.MatingMaterial(White), board.MatingMaterial(Black))
+	}
+}
+
+func TestLiveGame(t *testing.T) {
+	control, _ := ParseTimeControl("10+1")
+	clock, ft := NewClock(control), newFakeTime()
+	clock.Now, clock.After = ft.Now, ft.After
+	game := NewLiveGame(StartingPosition(), clock)
+
+	events, cancel := game.Subscribe()
+	defer cancel()
+	play := func(uci string) error {
+		move, err := NewUCIMove(uci, game.Board())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return game.Play(move)
+	}
+
+	if err := play("e2e4"); err != nil {
+		t.Fatalf("LiveGame.Play(e2e4) = %v", err)
+	}
+	ft.advance(3 * time.Second)
+	if err := game.OfferDraw(White); err != nil {
+		t.Fatalf("LiveGame.OfferDraw(White) = %v", err)
+	}
+	if err := game.AnswerDraw(White, true); !errors.Is(err, ErrNoDrawOffer) {
+		t.Errorf("LiveGame.AnswerDraw() of one's own offer = %v, want ErrNoDrawOffer", err)
+	}
+	if err := play("e7e5"); err != nil {
+		t.Fatalf("LiveGame.Play(e7e5) = %v", err)
+	}
+	if game.DrawOffer().IsValid() {
+		t.Errorf("LiveGame.DrawOffer() = %v after black moved, want none", game.DrawOffer())
+	}
+
+	// white's flag falls without anyone acting on the game, ending it and
+	// closing the subscription
+	ft.advance(20 * time.Second)
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if err := play("g1f3"); !errors.Is(err, ErrGameOver) {
+		t.Errorf("LiveGame.Play() after the game ended = %v, want ErrGameOver", err)
+	}
+
+	want := []Event{
+		{Type: EventMove, Ply: 1, SAN: "e4", Side: White, White: 11 * time.Second, Black: 10 * time.Second},
+		{Type: EventDrawOffer, Ply: 1, Side: White},
+		{Type: EventDrawDeclined, Ply: 2, Side: Black},
+		{Type: EventMove, Ply: 2, SAN: "e5", Side: Black, White: 11 * time.Second, Black: 8 * time.Second},
+		{Type: EventClock, Ply: 2, Black: 8 * time.Second},
+		{Type: EventEnd, Ply: 2, Black: 8 * time.Second, Outcome: Outcome{Black, EndTimeout}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i, e := range got {
+		e.Move, e.FEN = Move{}, ""
+		if e != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	// a late subscriber gets the whole game
+	late, _ := game.Subscribe()
+	n := 0
+	for range late {
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("late subscriber got %d events, want %d", n, len(want))
+	}
+
+	// with a delay the flag falls only once the delay has run out too
+	control, _ = ParseTimeControl("10+2")
+	control.Type = SimpleDelay
+	clock, ft = NewClock(control), newFakeTime()
+	clock.Now, clock.After = ft.Now, ft.After
+	game = NewLiveGame(StartingPosition(), clock)
+	play("e2e4")
+	events, _ = game.Subscribe()
+	ft.advance(11 * time.Second)
+	if outcome := game.Outcome(); outcome.IsOver() {
+		t.Errorf("LiveGame.Outcome() = %+v within the delay, want the game going on", outcome)
+	}
+	ft.advance(time.Second)
+	for range events {
+	}
+	if outcome := game.Outcome(); outcome != (Outcome{White, EndTimeout}) {
+		t.Errorf("LiveGame.Outcome() = %+v after the delay, want white winning on time", outcome)
+	}
+}
+
+func TestLiveGameEnd(t *testing.T) {
+	game := NewLiveGame(StartingPosition(), nil)
+	game.OfferDraw(Black)
+	if err := game.AnswerDraw(White, true); err != nil || game.Outcome() != (Outcome{Reason: EndAgreement}) {
+		t.Errorf("LiveGame.AnswerDraw(White, true) = %v, outcome %+v, want a draw by agreement", err, game.Outcome())
+	}
+	if err := game.Resign(White); !errors.Is(err, ErrGameOver) {
+		t.Errorf("LiveGame.Resign() after the game ended = %v, want ErrGameOver", err)
+	}
+
+	game = NewLiveGame(StartingPosition(), nil)
+	game.Resign(White)
+	if outcome := game.Outcome(); outcome != (Outcome{Black, EndResignation}) || outcome.Result() != "0-1" {
+		t.Errorf("LiveGame.Outcome() = %+v after white resigned, want black winning", outcome)
+	}
+
+	// the board ending the game ends it live too
+	board, _ := NewBoard("6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1")
+	game = NewLiveGame(board, nil)
+	move, _ := NewUCIMove("a1a8", board)
+	game.Play(move)
+	events := game.Events()
+	if last := events[len(events)-1]; last.Type != EventEnd || last.Outcome != (Outcome{White, EndCheckmate}) {
+		t.Errorf("last event = %+v, want the game ending in checkmate", last)
+	}
+}
+
+func TestSearchMoveTime(t *testing.T) {
+	// every reading of the time moves it on a second, so the search runs out
+	// of time a few thousand nodes into its second iteration
+	ft := newFakeTime()
+	s := Searcher{Now: func() time.Time {
+		ft.advance(time.Second)
+		return ft.Now()
+	}}
+
+	start := time.Now()
+	result := s.Search(StartingPosition(), SearchLimits{MoveTime: 3 * time.Second})
+	if !result.Move.IsValid() || result.Depth < 1 || result.Depth > 4 || result.Time < 3*time.Second {
+		t.Errorf("Searcher.Search() = %s at depth %d after %v, want a shallow search of at least 3s", result.Move.UCI(), result.Depth, result.Time)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Searcher.Search() took %v of real time", elapsed)
+	}
+
+	again := s.Search(StartingPosition(), SearchLimits{MoveTime: 3 * time.Second})
+	if again.Nodes != result.Nodes || again.Depth != result.Depth {
+		t.Errorf("Searcher.Search() searched %d nodes to depth %d, then %d to %d, want the same", result.Nodes, result.Depth, again.Nodes, again.Depth)
+	}
+}
@@ -0,0 +1,288 @@
+package chess
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a time control's Increment is applied to each move
+type IncrementType int
+
+const (
+	FischerIncrement IncrementType = iota // added to the clock after each move
+	BronsteinDelay                        // the time a move took given back, up to the increment
+	SimpleDelay                           // the clock only starts running after the increment
+)
+
+func (t IncrementType) String() string {
+	switch t {
+	case FischerIncrement:
+		return "Fischer increment"
+	case BronsteinDelay:
+		return "Bronstein delay"
+	case SimpleDelay:
+		return "simple delay"
+	default:
+		return ""
+	}
+}
+
+// A period of a time control: Moves moves, or the rest of the game if 0, to
+// be played in Time
+type TimeStage struct {
+	Moves     int
+	Time      time.Duration
+	Increment time.Duration
+}
+
+// The time each player has for the game. The last stage repeats, so 40/5400
+// is 40 moves in 90 minutes again and again, and a single stage without a
+// move count is sudden death.
+type TimeControl struct {
+	Stages []TimeStage
+	Type   IncrementType
+}
+
+// ParseTimeControl reads a time control in the form of the PGN TimeControl
+// tag, in seconds and with increments as "+n", e.g. "300+2", or
+// "40/5400+30:1800+30" for 40 moves in 90 minutes then 30 minutes for the
+// rest of the game, 30 seconds added each move
+func ParseTimeControl(s string) (TimeControl, error) {
+	var control TimeControl
+	for _, field := range strings.Split(s, ":") {
+		var stage TimeStage
+		var err error
+
+		if i := strings.IndexByte(field, '/'); i != -1 {
+			if stage.Moves, err = strconv.Atoi(field[:i]); err != nil || stage.Moves <= 0 {
+				return control, fmt.Errorf("invalid time control %q: bad move count in %q", s, field)
+			}
+			field = field[i+1:]
+		}
+		if i := strings.IndexByte(field, '+'); i != -1 {
+			if stage.Increment, err = parseSeconds(field[i+1:]); err != nil {
+				return control, fmt.Errorf("invalid time control %q: bad increment in %q", s, field)
+			}
+			field = field[:i]
+		}
+		if stage.Time, err = parseSeconds(field); err != nil || stage.Time <= 0 {
+			return control, fmt.Errorf("invalid time control %q: bad time in %q", s, field)
+		}
+
+		control.Stages = append(control.Stages, stage)
+	}
+	return control, nil
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid seconds %q", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// String returns the time control as a PGN TimeControl tag would have it
+func (tc TimeControl) String() string {
+	fields := make([]string, len(tc.Stages))
+	for i, stage := range tc.Stages {
+		if stage.Moves > 0 {
+			fields[i] = strconv.Itoa(stage.Moves) + "/"
+		}
+		fields[i] += strconv.FormatFloat(stage.Time.Seconds(), 'f', -1, 64)
+		if stage.Increment > 0 {
+			fields[i] += "+" + strconv.FormatFloat(stage.Increment.Seconds(), 'f', -1, 64)
+		}
+	}
+	return strings.Join(fields, ":")
+}
+
+// A chess clock for both players. Only the running side's clock counts
+// down, and pressing it applies the increment or delay and starts the
+// other's.
+type Clock struct {
+	Control TimeControl
+
+	// Now tells the time, time.Now if it's nil, and After waits for it to
+	// pass, time.After if it's nil, so tests can control it
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	remaining  [3]time.Duration // by SideColor, up to the start of the turn
+	moves      [3]int
+	stage      [3]int // index into Control.Stages
+	stageMoves [3]int // moves completed in the current stage
+	running    SideColor
+	started    time.Time
+	flagged    SideColor
+}
+
+func NewClock(control TimeControl) *Clock {
+	c := &Clock{Control: control}
+	if len(control.Stages) > 0 {
+		c.remaining[White] = control.Stages[0].Time
+		c.remaining[Black] = control.Stages[0].Time
+	}
+	return c
+}
+
+func (c *Clock) time() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Clock) after(d time.Duration) <-chan time.Time {
+	if c.After != nil {
+		return c.After(d)
+	}
+	return time.After(d)
+}
+
+// Start runs the side's clock, stopping the other's without counting it as
+// a move
+func (c *Clock) Start(side SideColor) {
+	c.Stop()
+	c.running, c.started = side, c.time()
+}
+
+// Stop pauses the running clock, keeping the time it used
+func (c *Clock) Stop() {
+	if c.running.IsValid() {
+		c.remaining[c.running] = c.Remaining(c.running)
+		c.running = 0
+	}
+}
+
+// Running returns the side whose clock is running, 0 if neither is
+func (c *Clock) Running() SideColor {
+	return c.running
+}
+
+// Remaining returns the time the side has left, counting its turn so far if
+// its clock is running
+func (c *Clock) Remaining(side SideColor) time.Duration {
+	left := c.remaining[side]
+	if side == c.running {
+		left -= c.used(c.time().Sub(c.started))
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// The time a turn that took elapsed uses up
+func (c *Clock) used(elapsed time.Duration) time.Duration {
+	if c.Control.Type == SimpleDelay {
+		if elapsed -= c.currentStage(c.running).Increment; elapsed < 0 {
+			return 0
+		}
+	}
+	return elapsed
+}
+
+// Moves returns the number of moves the side has completed
+func (c *Clock) Moves(side SideColor) int {
+	return c.moves[side]
+}
+
+func (c *Clock) currentStage(side SideColor) TimeStage {
+	if len(c.Control.Stages) == 0 {
+		return TimeStage{}
+	}
+	return c.Control.Stages[c.stage[side]]
+}
+
+// Flagged returns the side that has run out of time, 0 if neither has
+func (c *Clock) Flagged() SideColor {
+	if !c.flagged.IsValid() && c.running.IsValid() && c.Remaining(c.running) == 0 {
+		c.flagged = c.running
+	}
+	return c.flagged
+}
+
+// Press ends the running side's turn and starts the other's. It reports
+// false, leaving the clock stopped, if the side ran out of time first.
+func (c *Clock) Press() bool {
+	side := c.running
+	if !side.IsValid() {
+		return false
+	}
+
+	elapsed := c.time().Sub(c.started)
+	if c.Flagged().IsValid() {
+		c.remaining[side], c.running = 0, 0
+		return false
+	}
+
+	stage := c.currentStage(side)
+	c.remaining[side] -= c.used(elapsed)
+	switch c.Control.Type {
+	case FischerIncrement:
+		c.remaining[side] += stage.Increment
+	case BronsteinDelay:
+		if elapsed < stage.Increment {
+			c.remaining[side] += elapsed
+		} else {
+			c.remaining[side] += stage.Increment
+		}
+	}
+
+	// a stage's moves done, the next one's time is added, the last stage
+	// repeating
+	c.moves[side]++
+	if c.stageMoves[side]++; stage.Moves > 0 && c.stageMoves[side] == stage.Moves {
+		c.stageMoves[side] = 0
+		if c.stage[side] < len(c.Control.Stages)-1 {
+			c.stage[side]++
+		}
+		c.remaining[side] += c.currentStage(side).Time
+	}
+
+	c.running = 0
+	c.Start(side ^ 0b11)
+	return true
+}
+
+// Play plays the move on the board and presses the clock for the side that
+// made it, starting the clock first if it wasn't running. An illegal move
+// leaves the clock as it was. If the side ran out of time before, the move is
+// taken back and ErrTimeout returned.
+func (c *Clock) Play(board *Board, move Move) error {
+	side := board.SideToMove
+	if err := board.PlayMove(move); err != nil {
+		return err
+	}
+	if c.running != side {
+		c.Start(side)
+	}
+
+	if !c.Press() {
+		board.UnmakeMove()
+		return &MoveError{move.UCI(), ErrTimeout}
+	}
+	return nil
+}
+
+// Outcome returns the outcome of the game on the board, ended by the rules
+// or by a side running out of time, which draws if the other side can't
+// checkmate
+func (c *Clock) Outcome(board *Board) Outcome {
+	if outcome := board.Outcome(); outcome.IsOver() {
+		return outcome
+	}
+
+	flagged := c.Flagged()
+	switch {
+	case !flagged.IsValid():
+		return Outcome{}
+	case board.MatingMaterial(flagged ^ 0b11):
+		return Outcome{flagged ^ 0b11, EndTimeout}
+	default:
+		return Outcome{Reason: EndTimeout}
+	}
+}
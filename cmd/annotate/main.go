@@ -0,0 +1,100 @@
+// Command annotate searches every position of the games in PGN files and
+// writes them back out as PGN with inaccuracies (?!), mistakes (?) and
+// blunders (??) marked, each commented with the better line.
+//
+//	annotate [-depth n] [-time d] [-nodes n] [-o out.pgn] games.pgn...
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	depth := flag.Int("depth", 0, "search depth in plies per position, 0 for no limit")
+	moveTime := flag.Duration("time", 0, "search time per position, 500ms if no limit is given")
+	nodes := flag.Int("nodes", 0, "nodes to search per position, 0 for no limit")
+	inaccuracy := flag.Int("inaccuracy", 50, "centipawn loss of an inaccuracy")
+	mistake := flag.Int("mistake", 100, "centipawn loss of a mistake")
+	blunder := flag.Int("blunder", 300, "centipawn loss of a blunder")
+	out := flag.String("o", "", "output file, standard output if not given")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-depth n] [-time d] [-nodes n] [-o out.pgn] games.pgn...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	limits := chess.SearchLimits{Depth: *depth, MoveTime: *moveTime, Nodes: *nodes}
+	if limits == (chess.SearchLimits{}) {
+		limits.MoveTime = 500 * time.Millisecond
+	}
+	annotator := chess.NewAnnotator(limits)
+	annotator.Inaccuracy, annotator.Mistake, annotator.Blunder = *inaccuracy, *mistake, *blunder
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	games, skipped := 0, 0
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		pgn := chess.NewPGNReader(f)
+		for {
+			game, err := pgn.Next()
+			var pgnErr *chess.PGNError
+			if err == io.EOF {
+				break
+			} else if errors.As(err, &pgnErr) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				os.Exit(1)
+			}
+
+			annotated, err := annotator.Annotate(game)
+			if err == nil {
+				_, err = annotated.WriteTo(bw)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			}
+			games++
+		}
+		f.Close()
+	}
+
+	if err := bw.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d games annotated, %d skipped\n", games, skipped)
+}
@@ -0,0 +1,43 @@
+// Command chess-server serves games over an HTTP/JSON API: games are created
+// from a FEN, optionally with a clock, moves submitted in SAN or UCI, and the
+// position, legal moves, history and outcome read back. Draws can be offered
+// and games resigned, and anyone can follow a game live as server-sent
+// events. Games are kept in memory, or as files in a directory with -data.
+// Games left unplayed for -idle are unloaded when the server is full, and
+// loaded again from the store if anyone comes back to them.
+//
+//	chess-server [-addr :8080] [-data dir] [-max-games n] [-idle 30m]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	data := flag.String("data", "", "directory to keep games in, memory only if not given")
+	maxGames := flag.Int("max-games", 10000, "number of games that can be loaded at once")
+	idle := flag.Duration("idle", 30*time.Minute, "how long a game goes unused before it can be unloaded, 0 for never")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-addr :8080] [-data dir] [-max-games n] [-idle 30m]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var s store = newMemoryStore()
+	if *data != "" {
+		if err := os.MkdirAll(*data, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		s = dirStore{*data}
+	}
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, newServer(s, *maxGames, *idle)))
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+// Serves the games of a store over HTTP, keeping the ones in progress loaded.
+// A game is dropped once it ends, and a finished game read again is loaded
+// for the request only. No more than maxGames are loaded at once; when that
+// many are, games nobody has used for idle are dropped to make room, to be
+// loaded again from the store if they're wanted. Games with a clock running
+// are kept, as they end on their own when a flag falls.
+type server struct {
+	store    store
+	maxGames int
+	idle     time.Duration // zero keeps games until they end
+	newClock func(chess.TimeControl) *chess.Clock
+	now      func() time.Time
+
+	mu    sync.Mutex
+	games map[string]*game
+}
+
+func newServer(s store, maxGames int, idle time.Duration) *server {
+	return &server{store: s, maxGames: maxGames, idle: idle, newClock: chess.NewClock, now: time.Now, games: make(map[string]*game)}
+}
+
+// A game loaded from the store, with its moves replayed
+type game struct {
+	mu   sync.Mutex
+	rec  record
+	live *chess.LiveGame
+	sans []string // the moves played in SAN
+
+	// guarded by server.mu
+	users    int       // requests using the game
+	lastUsed time.Time // when the last of them finished
+	watched  bool      // its clock is running
+}
+
+func loadGame(r record) (*game, error) {
+	board, err := chess.NewBoard(r.StartFEN)
+	if err != nil {
+		return nil, fmt.Errorf("game %s: %v", r.ID, err)
+	}
+
+	g := &game{rec: r, live: chess.NewLiveGame(board, nil)}
+	for _, uci := range r.Moves {
+		move, err := chess.NewUCIMove(uci, board)
+		if err != nil {
+			return nil, fmt.Errorf("game %s: %v", r.ID, err)
+		}
+		g.sans = append(g.sans, board.SAN(move))
+		board.PlayMove(move)
+		if err := g.live.Play(move); err != nil {
+			return nil, fmt.Errorf("game %s: %v", r.ID, err)
+		}
+	}
+
+	// games drawn, resigned or lost on time don't end on the board
+	if r.Reason != "" && !g.live.Outcome().IsOver() {
+		winner, _ := parseColor(r.Winner)
+		outcome := chess.Outcome{Winner: winner}
+		for reason := chess.EndCheckmate; reason.String() != ""; reason++ {
+			if reason.String() == r.Reason {
+				outcome.Reason = reason
+			}
+		}
+		if !outcome.IsOver() {
+			return nil, fmt.Errorf("game %s: unknown end %q", r.ID, r.Reason)
+		}
+		g.live.Adjudicate(outcome)
+	}
+	return g, nil
+}
+
+// Waits for a timed game's flag to fall, unless it ends some other way first
+func (s *server) watch(g *game) {
+	for !g.live.Outcome().IsOver() {
+		events, _ := g.live.Subscribe()
+		for range events {
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s.finish(g)
+}
+
+// Stores how the game ended, if it has, and drops it; g.mu has to be held
+func (s *server) finish(g *game) {
+	outcome := g.live.Outcome()
+	if !outcome.IsOver() || g.rec.Reason != "" {
+		return
+	}
+
+	rec := g.rec
+	rec.Winner, rec.Reason = colorName(outcome.Winner), outcome.Reason.String()
+	if err := s.store.Put(rec); err != nil {
+		// kept loaded, as the store doesn't know the game is over
+		log.Printf("game %s: %v", rec.ID, err)
+		return
+	}
+	g.rec = rec
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.games[rec.ID] == g {
+		delete(s.games, rec.ID)
+	}
+}
+
+// Returns the game for a request, which calls release when it's done with it
+func (s *server) game(id string) (*game, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.games[id]; ok {
+		g.users++
+		return g, nil
+	}
+	r, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	g, err := loadGame(r)
+	if err != nil {
+		return nil, err
+	}
+	// a reloaded game is untimed, so only moves end it
+	if !g.live.Outcome().IsOver() {
+		if !s.makeRoom() {
+			return nil, errTooManyGames
+		}
+		s.games[id] = g
+	}
+	g.users++
+	return g, nil
+}
+
+func (s *server) release(g *game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.users--
+	g.lastUsed = s.now()
+}
+
+// Drops idle games if there's no room for another, and reports whether there
+// is then; s.mu has to be held
+func (s *server) makeRoom() bool {
+	if len(s.games) < s.maxGames {
+		return true
+	}
+	if s.idle > 0 {
+		now := s.now()
+		for id, g := range s.games {
+			if g.users == 0 && !g.watched && now.Sub(g.lastUsed) >= s.idle {
+				delete(s.games, id)
+			}
+		}
+	}
+	return len(s.games) < s.maxGames
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Routes:
+//
+//	POST /games             {"fen": "...", "time_control": "300+2", "increment": "fischer"},
+//	                        all optional, the game untimed without a time control
+//	GET  /games/{id}        the position, the moves played, the clock and the outcome
+//	GET  /games/{id}/moves  the legal moves
+//	POST /games/{id}/moves  {"move": "Nf3"}, in SAN or UCI
+//	POST /games/{id}/draw   {"side": "white", "action": "offer"}, or "accept" or "decline"
+//	POST /games/{id}/resign {"side": "white"}
+//	GET  /games/{id}/events the game's events as server-sent events, from the first
+//	GET  /games/{id}/pgn    the game as PGN
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "games" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		route(w, r, map[string]http.HandlerFunc{http.MethodPost: s.create})
+		return
+	}
+
+	g, err := s.game(parts[1])
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err == errTooManyGames:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer s.release(g)
+
+	switch {
+	case len(parts) == 2:
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.state(w, g) },
+		})
+	case parts[2] == "moves":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:  func(w http.ResponseWriter, r *http.Request) { s.legalMoves(w, g) },
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.move(w, r, g) },
+		})
+	case parts[2] == "draw":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.draw(w, r, g) },
+		})
+	case parts[2] == "resign":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.resign(w, r, g) },
+		})
+	case parts[2] == "events":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.events(w, r, g) },
+		})
+	case parts[2] == "pgn":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.pgn(w, g) },
+		})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func route(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	if h, ok := handlers[r.Method]; ok {
+		h(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func (s *server) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FEN         string `json:"fen"`
+		TimeControl string `json:"time_control"`
+		Increment   string `json:"increment"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var clock *chess.Clock
+	if req.TimeControl != "" {
+		control, err := chess.ParseTimeControl(req.TimeControl)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		switch req.Increment {
+		case "", "fischer":
+			control.Type = chess.FischerIncrement
+		case "bronstein":
+			control.Type = chess.BronsteinDelay
+		case "simple":
+			control.Type = chess.SimpleDelay
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown increment %q", req.Increment))
+			return
+		}
+		clock = s.newClock(control)
+	}
+
+	board := chess.StartingPosition()
+	if req.FEN != "" {
+		var err error
+		if board, err = chess.NewBoardStrict(req.FEN); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	g := &game{
+		rec:  record{ID: newID(), StartFEN: board.String(), Created: time.Now().UTC(), TimeControl: req.TimeControl},
+		live: chess.NewLiveGame(board, clock),
+	}
+	if err := s.add(g); err != nil {
+		status := http.StatusInternalServerError
+		if err == errTooManyGames {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/games/"+g.rec.ID)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(w, http.StatusCreated, g.state())
+}
+
+var errTooManyGames = errors.New("too many games in progress")
+
+// Stores a new game and keeps it loaded until it ends or goes idle
+func (s *server) add(g *game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	over := g.live.Outcome().IsOver()
+	if !over && !s.makeRoom() {
+		return errTooManyGames
+	}
+	if err := s.store.Put(g.rec); err != nil {
+		return err
+	}
+	if !over {
+		g.lastUsed = s.now()
+		s.games[g.rec.ID] = g
+	}
+	return nil
+}
+
+func (s *server) state(w http.ResponseWriter, g *game) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(w, http.StatusOK, g.state())
+}
+
+func (s *server) legalMoves(w http.ResponseWriter, g *game) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	moves := []moveJSON{}
+	if board := g.live.Board(); !g.live.Outcome().IsOver() {
+		for _, m := range board.Moves() {
+			moves = append(moves, moveJSON{m.UCI(), board.SAN(m)})
+		}
+	}
+	writeJSON(w, http.StatusOK, moves)
+}
+
+func (s *server) move(w http.ResponseWriter, r *http.Request, g *game) {
+	var req struct {
+		Move string `json:"move"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.live.Outcome().IsOver() {
+		s.finish(g) // if the flag just fell
+		writeError(w, http.StatusConflict, "the game is over")
+		return
+	}
+
+	// UCI first, as SAN reads some long algebraic moves too, and tried on a
+	// copy, so the move is stored before anyone sees it played
+	board := g.live.Board()
+	move, err := chess.NewUCIMove(req.Move, board)
+	if errors.Is(err, chess.ErrInvalidNotation) {
+		move, err = chess.NewMove(req.Move, board)
+	}
+	var san string
+	if err == nil {
+		san = board.SAN(move)
+		err = board.PlayMove(move)
+	}
+	switch {
+	case errors.Is(err, chess.ErrInvalidNotation):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	rec := g.rec
+	rec.Moves = append(rec.Moves[:len(rec.Moves):len(rec.Moves)], move.UCI())
+	if err := s.store.Put(rec); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := g.live.Play(move); err != nil {
+		// the clock ran out first, so the game is stored again without it
+		s.finish(g)
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	g.rec = rec
+	g.sans = append(g.sans, san)
+
+	// the clock starts with the first move, and the game is kept loaded from
+	// then on to see the flag fall
+	if _, _, timed := g.live.Remaining(); timed && len(g.sans) == 1 {
+		s.mu.Lock()
+		g.watched = true
+		s.mu.Unlock()
+		go s.watch(g)
+	}
+	s.finish(g)
+
+	writeJSON(w, http.StatusOK, g.state())
+}
+
+func (s *server) draw(w http.ResponseWriter, r *http.Request, g *game) {
+	var req struct {
+		Side   string `json:"side"`
+		Action string `json:"action"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	side, ok := parseColor(req.Side)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown side %q", req.Side))
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var err error
+	switch req.Action {
+	case "offer":
+		err = g.live.OfferDraw(side)
+	case "accept", "decline":
+		err = g.live.AnswerDraw(side, req.Action == "accept")
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown action %q", req.Action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.finish(g)
+	writeJSON(w, http.StatusOK, g.state())
+}
+
+func (s *server) resign(w http.ResponseWriter, r *http.Request, g *game) {
+	var req struct {
+		Side string `json:"side"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	side, ok := parseColor(req.Side)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown side %q", req.Side))
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.live.Resign(side); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.finish(g)
+	writeJSON(w, http.StatusOK, g.state())
+}
+
+// Streams the game's events, every one so far and then each as it happens,
+// until the game ends. Each is numbered by its id, so a client reconnecting
+// with Last-Event-ID only gets the ones it missed.
+func (s *server) events(w http.ResponseWriter, r *http.Request, g *game) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	next := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = id + 1
+	}
+	events, cancel := g.live.Subscribe()
+	defer cancel()
+
+	// nothing more will come, which tells EventSource to stop reconnecting
+	if g.live.Outcome().IsOver() && next >= len(g.live.Events()) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for id := 0; ; {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if id >= next {
+				data, _ := json.Marshal(newEventJSON(e))
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, strings.ReplaceAll(e.Type.String(), " ", "_"), data)
+				flusher.Flush()
+			}
+			id++
+		case <-ping.C:
+			// keeps proxies from closing a quiet stream
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *server) pgn(w http.ResponseWriter, g *game) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	game := chess.NewGame(g.live.Board())
+	game.Tags["Site"] = "chess-server"
+	game.Tags["Date"] = g.rec.Created.Format("2006.01.02")
+	game.Result = g.live.Outcome().Result()
+	if g.rec.TimeControl != "" {
+		game.Tags["TimeControl"] = g.rec.TimeControl
+	}
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	game.WriteTo(w)
+}
+
+type moveJSON struct {
+	UCI string `json:"uci"`
+	SAN string `json:"san"`
+}
+
+type outcomeJSON struct {
+	Result string `json:"result"`
+	Winner string `json:"winner,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type clockJSON struct {
+	White int64 `json:"white_ms"`
+	Black int64 `json:"black_ms"`
+}
+
+type stateJSON struct {
+	ID          string      `json:"id"`
+	FEN         string      `json:"fen"`
+	StartFEN    string      `json:"start_fen"`
+	SideToMove  string      `json:"side_to_move"`
+	InCheck     bool        `json:"in_check"`
+	Moves       []moveJSON  `json:"moves"`
+	TimeControl string      `json:"time_control,omitempty"`
+	Clock       *clockJSON  `json:"clock,omitempty"`
+	DrawOffer   string      `json:"draw_offer,omitempty"`
+	Outcome     outcomeJSON `json:"outcome"`
+}
+
+type eventJSON struct {
+	Ply     int          `json:"ply"`
+	FEN     string       `json:"fen"`
+	Move    *moveJSON    `json:"move,omitempty"`
+	Side    string       `json:"side,omitempty"`
+	Clock   *clockJSON   `json:"clock,omitempty"`
+	Outcome *outcomeJSON `json:"outcome,omitempty"`
+}
+
+func newEventJSON(e chess.Event) eventJSON {
+	ej := eventJSON{Ply: e.Ply, FEN: e.FEN, Side: colorName(e.Side)}
+	if e.Type == chess.EventMove {
+		ej.Move = &moveJSON{e.Move.UCI(), e.SAN}
+	}
+	if e.White != 0 || e.Black != 0 {
+		ej.Clock = &clockJSON{e.White.Milliseconds(), e.Black.Milliseconds()}
+	}
+	if e.Type == chess.EventEnd {
+		outcome := newOutcomeJSON(e.Outcome)
+		ej.Outcome = &outcome
+	}
+	return ej
+}
+
+func colorName(c chess.SideColor) string {
+	switch c {
+	case chess.White:
+		return "white"
+	case chess.Black:
+		return "black"
+	}
+	return ""
+}
+
+func parseColor(s string) (chess.SideColor, bool) {
+	switch s {
+	case "white":
+		return chess.White, true
+	case "black":
+		return chess.Black, true
+	}
+	return 0, false
+}
+
+func newOutcomeJSON(o chess.Outcome) outcomeJSON {
+	return outcomeJSON{o.Result(), colorName(o.Winner), o.Reason.String()}
+}
+
+// The game as the API returns it; g.mu has to be held
+func (g *game) state() stateJSON {
+	moves := make([]moveJSON, len(g.rec.Moves))
+	for i, uci := range g.rec.Moves {
+		moves[i] = moveJSON{uci, g.sans[i]}
+	}
+
+	board := g.live.Board()
+	state := stateJSON{
+		ID:         g.rec.ID,
+		FEN:        board.String(),
+		StartFEN:   g.rec.StartFEN,
+		SideToMove: colorName(board.SideToMove),
+		InCheck:    board.InCheck(board.SideToMove),
+		Moves:      moves,
+		DrawOffer:  colorName(g.live.DrawOffer()),
+		Outcome:    newOutcomeJSON(g.live.Outcome()),
+	}
+	if white, black, ok := g.live.Remaining(); ok {
+		state.TimeControl = g.rec.TimeControl
+		state.Clock = &clockJSON{white.Milliseconds(), black.Milliseconds()}
+	}
+	return state
+}
+
+// Reads a JSON request body, which may be empty
+func readJSON(r *http.Request, v interface{}) error {
+	if r.Body == nil {
+		return nil
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+// Sends the request and returns the response, with its JSON body decoded
+// into v unless it's nil
+func do(t *testing.T, s *server, method, path, body string, v interface{}) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	resp := w.Result()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func newGame(t *testing.T, s *server, body string) stateJSON {
+	t.Helper()
+	var state stateJSON
+	if resp := do(t, s, http.MethodPost, "/games", body, &state); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /games %s = %d, want %d", body, resp.StatusCode, http.StatusCreated)
+	} else if loc := resp.Header.Get("Location"); loc != "/games/"+state.ID {
+		t.Errorf("POST /games Location = %q, want %q", loc, "/games/"+state.ID)
+	}
+	return state
+}
+
+// Plays the moves of the game, checking each is accepted
+func play(t *testing.T, s *server, id string, moves ...string) {
+	t.Helper()
+	for _, move := range moves {
+		if resp := do(t, s, http.MethodPost, "/games/"+id+"/moves", `{"move": "`+move+`"}`, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /games/{id}/moves %s = %d, want %d", move, resp.StatusCode, http.StatusOK)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	s := newServer(newMemoryStore(), 10, time.Hour)
+	id := newGame(t, s, "").ID
+
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodGet, "/", http.StatusNotFound, ""},
+		{http.MethodGet, "/players", http.StatusNotFound, ""},
+		{http.MethodGet, "/games", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/games/0123456789abcdef", http.StatusNotFound, ""},
+		{http.MethodGet, "/games/" + id, http.StatusOK, ""},
+		{http.MethodDelete, "/games/" + id, http.StatusMethodNotAllowed, "GET"},
+		{http.MethodGet, "/games/" + id + "/moves", http.StatusOK, ""},
+		{http.MethodPut, "/games/" + id + "/moves", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodGet, "/games/" + id + "/draw", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/games/" + id + "/resign", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/games/" + id + "/events", http.StatusMethodNotAllowed, "GET"},
+		{http.MethodPost, "/games/" + id + "/pgn", http.StatusMethodNotAllowed, "GET"},
+		{http.MethodGet, "/games/" + id + "/pgn", http.StatusOK, ""},
+		{http.MethodGet, "/games/" + id + "/clock", http.StatusNotFound, ""},
+		{http.MethodGet, "/games/" + id + "/moves/e4", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		resp := do(t, s, test.method, test.path, "", nil)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s = %d, want %d", test.method, test.path, resp.StatusCode, test.status)
+		}
+		if allow := resp.Header.Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s Allow = %q, want %q", test.method, test.path, allow, test.allow)
+		}
+	}
+
+	var moves []moveJSON
+	do(t, s, http.MethodGet, "/games/"+id+"/moves", "", &moves)
+	if len(moves) != 20 {
+		t.Errorf("GET /games/{id}/moves = %d moves, want 20", len(moves))
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s := newServer(newMemoryStore(), 10, time.Hour)
+
+	state := newGame(t, s, `{"fen": "4k3/8/8/8/8/8/8/4K2R w K - 0 1", "time_control": "300+2"}`)
+	if state.FEN != "4k3/8/8/8/8/8/8/4K2R w K - 0 1" || state.SideToMove != "white" || state.Clock == nil || state.Clock.White != 300000 {
+		t.Errorf("POST /games = %+v, want the position with 5 minutes on the clock", state)
+	}
+
+	tests := []string{
+		`{"fen": "8/8/8/8/8/8/8/8 w - - 0 1"}`,
+		`{"fen": "rnbqkbnr/pppppppp/8/8"}`,
+		`{"time_control": "fast"}`,
+		`{"time_control": "300", "increment": "hourglass"}`,
+		`{"colour": "white"}`,
+		`{`,
+	}
+	for _, body := range tests {
+		if resp := do(t, s, http.MethodPost, "/games", body, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST /games %s = %d, want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMoves(t *testing.T) {
+	s := newServer(newMemoryStore(), 10, time.Hour)
+	path := "/games/" + newGame(t, s, "").ID + "/moves"
+
+	tests := []struct {
+		move   string
+		status int
+	}{
+		{"f3", http.StatusOK},
+		{"e7e5", http.StatusOK},
+		{"e2e5", http.StatusUnprocessableEntity}, // chess.ErrIllegalMove
+		{"Ke3", http.StatusUnprocessableEntity},
+		{"Zz9", http.StatusBadRequest}, // chess.ErrInvalidNotation
+		{"g4", http.StatusOK},
+		{"Qh4#", http.StatusOK},
+		{"a3", http.StatusConflict}, // chess.ErrGameOver
+	}
+	for _, test := range tests {
+		if resp := do(t, s, http.MethodPost, path, `{"move": "`+test.move+`"}`, nil); resp.StatusCode != test.status {
+			t.Errorf("POST %s %s = %d, want %d", path, test.move, resp.StatusCode, test.status)
+		}
+	}
+
+	var state stateJSON
+	do(t, s, http.MethodGet, strings.TrimSuffix(path, "/moves"), "", &state)
+	if len(state.Moves) != 4 || state.Moves[1] != (moveJSON{"e7e5", "e5"}) || state.Outcome.Result != "0-1" || state.Outcome.Reason != "checkmate" {
+		t.Errorf("GET /games/{id} = %+v, want 4 moves ending in checkmate", state)
+	}
+
+	resp := do(t, s, http.MethodGet, strings.TrimSuffix(path, "/moves")+"/pgn", "", nil)
+	if body := readAll(t, resp); !strings.Contains(body, `[Result "0-1"]`) {
+		t.Errorf("GET /games/{id}/pgn = %q, want a 0-1 result", body)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	s := newServer(newMemoryStore(), 10, time.Hour)
+	path := "/games/" + newGame(t, s, "").ID + "/draw"
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"side": "black", "action": "accept"}`, http.StatusConflict}, // chess.ErrNoDrawOffer
+		{`{"side": "red", "action": "offer"}`, http.StatusBadRequest},
+		{`{"side": "white", "action": "claim"}`, http.StatusBadRequest},
+		{`{"side": "white", "action": "offer"}`, http.StatusOK},
+		{`{"side": "white", "action": "accept"}`, http.StatusConflict}, // its own offer
+		{`{"side": "black", "action": "decline"}`, http.StatusOK},
+		{`{"side": "black", "action": "accept"}`, http.StatusConflict},
+		{`{"side": "black", "action": "offer"}`, http.StatusOK},
+		{`{"side": "white", "action": "accept"}`, http.StatusOK},
+		{`{"side": "white", "action": "offer"}`, http.StatusConflict}, // chess.ErrGameOver
+	}
+	for _, test := range tests {
+		if resp := do(t, s, http.MethodPost, path, test.body, nil); resp.StatusCode != test.status {
+			t.Errorf("POST %s %s = %d, want %d", path, test.body, resp.StatusCode, test.status)
+		}
+	}
+
+	var state stateJSON
+	do(t, s, http.MethodGet, strings.TrimSuffix(path, "/draw"), "", &state)
+	if state.Outcome != (outcomeJSON{"1/2-1/2", "", "agreement"}) || state.DrawOffer != "" {
+		t.Errorf("GET /games/{id} = %+v, want a draw by agreement", state)
+	}
+}
+
+func TestResign(t *testing.T) {
+	s := newServer(newMemoryStore(), 10, time.Hour)
+	id := newGame(t, s, "").ID
+	path := "/games/" + id + "/resign"
+
+	if resp := do(t, s, http.MethodPost, path, `{"side": "green"}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST %s by green = %d, want %d", path, resp.StatusCode, http.StatusBadRequest)
+	}
+	var state stateJSON
+	if resp := do(t, s, http.MethodPost, path, `{"side": "white"}`, &state); resp.StatusCode != http.StatusOK {
+		t.Errorf("POST %s = %d, want %d", path, resp.StatusCode, http.StatusOK)
+	} else if state.Outcome != (outcomeJSON{"0-1", "black", "resignation"}) {
+		t.Errorf("POST %s outcome = %+v, want black winning by resignation", path, state.Outcome)
+	}
+	if resp := do(t, s, http.MethodPost, path, `{"side": "black"}`, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("POST %s after the game ended = %d, want %d", path, resp.StatusCode, http.StatusConflict)
+	}
+
+	// a finished game streams every event it had, or nothing after the last
+	resp := do(t, s, http.MethodGet, "/games/"+id+"/events", "", nil)
+	if body := readAll(t, resp); !strings.Contains(body, "event: end\n") || !strings.Contains(body, `"reason":"resignation"`) {
+		t.Errorf("GET /games/{id}/events = %q, want the end of the game", body)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/games/"+id+"/events", nil)
+	r.Header.Set("Last-Event-ID", "0")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("GET /games/{id}/events after the last = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	resp = do(t, s, http.MethodGet, "/games/"+id+"/pgn", "", nil)
+	if body := readAll(t, resp); !strings.Contains(body, `[Result "0-1"]`) {
+		t.Errorf("GET /games/{id}/pgn = %q, want a 0-1 result", body)
+	}
+}
+
+func readAll(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestStore(t *testing.T) {
+	store := dirStore{t.TempDir()}
+	s := newServer(store, 10, time.Hour)
+
+	id := newGame(t, s, `{"time_control": "300+2"}`).ID
+	play(t, s, id, "e4", "c7c5", "Nf3")
+	ended := newGame(t, s, "").ID
+	do(t, s, http.MethodPost, "/games/"+ended+"/resign", `{"side": "black"}`, nil)
+
+	// only the game in progress stays loaded
+	if _, ok := s.games[ended]; ok || len(s.games) != 1 {
+		t.Errorf("server has %d games loaded, want only the one in progress", len(s.games))
+	}
+
+	r, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(r.Moves, " ") != "e2e4 c7c5 g1f3" || r.TimeControl != "300+2" || r.Reason != "" {
+		t.Errorf("dirStore.Get() = %+v, want the moves played and no end", r)
+	}
+	g, err := loadGame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(g.sans, " ") != "e4 c5 Nf3" || g.live.Board().SideToMove != chess.Black {
+		t.Errorf("loadGame() = %v, want the moves replayed", g.sans)
+	}
+
+	// a new server finds both games in the store, as they were left
+	s = newServer(store, 10, time.Hour)
+	var state stateJSON
+	do(t, s, http.MethodGet, "/games/"+id, "", &state)
+	if len(state.Moves) != 3 || state.Moves[2] != (moveJSON{"g1f3", "Nf3"}) || state.Outcome.Result != "*" || state.Clock != nil {
+		t.Errorf("GET /games/{id} reloaded = %+v, want the 3 moves, untimed", state)
+	}
+	do(t, s, http.MethodGet, "/games/"+ended, "", &state)
+	if state.Outcome != (outcomeJSON{"1-0", "white", "resignation"}) {
+		t.Errorf("GET /games/{id} reloaded = %+v, want white winning by resignation", state.Outcome)
+	}
+	if _, ok := s.games[ended]; ok {
+		t.Error("server keeps a finished game loaded after reading it")
+	}
+	play(t, s, id, "d6")
+
+	if _, err := loadGame(record{ID: "bad", StartFEN: chess.StartingPosition().String(), Moves: []string{"e2e5"}}); err == nil {
+		t.Error("loadGame() of an illegal move succeeded")
+	}
+	if _, err := loadGame(record{ID: "bad", StartFEN: chess.StartingPosition().String(), Reason: "boredom"}); err == nil {
+		t.Error("loadGame() of an unknown end succeeded")
+	}
+}
+
+func TestMaxGames(t *testing.T) {
+	s := newServer(newMemoryStore(), 2, time.Hour)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	s.newClock = func(control chess.TimeControl) *chess.Clock {
+		clock := chess.NewClock(control)
+		clock.After = func(time.Duration) <-chan time.Time { return nil } // no flag falls
+		return clock
+	}
+
+	first := newGame(t, s, "").ID
+	timed := newGame(t, s, `{"time_control": "60"}`).ID
+	play(t, s, timed, "e4")
+
+	if resp := do(t, s, http.MethodPost, "/games", "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("POST /games past the limit = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	// a game ending makes room for another
+	do(t, s, http.MethodPost, "/games/"+first+"/resign", `{"side": "white"}`, nil)
+	idle := newGame(t, s, "").ID
+	play(t, s, idle, "d4")
+
+	// an hour on, the idle game makes way, but not the one with its clock
+	// running
+	now = now.Add(time.Hour)
+	last := newGame(t, s, "").ID
+	if resp := do(t, s, http.MethodPost, "/games", "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("POST /games past the limit with no game idle = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	// the idle game is loaded again from the store, once there's room for it
+	if resp := do(t, s, http.MethodGet, "/games/"+idle, "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /games/{id} of an unloaded game past the limit = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	do(t, s, http.MethodPost, "/games/"+last+"/resign", `{"side": "black"}`, nil)
+	var state stateJSON
+	if resp := do(t, s, http.MethodGet, "/games/"+idle, "", &state); resp.StatusCode != http.StatusOK || len(state.Moves) != 1 {
+		t.Errorf("GET /games/{id} of an unloaded game = %d with moves %v, want %d with d4", resp.StatusCode, state.Moves, http.StatusOK)
+	}
+	play(t, s, idle, "d5")
+}
+
+func TestTimeout(t *testing.T) {
+	store := newMemoryStore()
+	s := newServer(store, 10, time.Hour)
+
+	var mu sync.Mutex
+	now, fire := time.Unix(0, 0), make(chan time.Time, 1)
+	s.newClock = func(control chess.TimeControl) *chess.Clock {
+		clock := chess.NewClock(control)
+		clock.Now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+		clock.After = func(time.Duration) <-chan time.Time { return fire }
+		return clock
+	}
+
+	id := newGame(t, s, `{"time_control": "60"}`).ID
+	do(t, s, http.MethodPost, "/games/"+id+"/moves", `{"move": "e4"}`, nil)
+
+	// black's flag falls with nobody there to see it
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	fire <- now
+
+	if resp := do(t, s, http.MethodPost, "/games/"+id+"/moves", `{"move": "e5"}`, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("POST /games/{id}/moves after the flag fell = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	if r, _ := store.Get(id); r.Winner != "white" || r.Reason != "timeout" || len(r.Moves) != 1 {
+		t.Errorf("memoryStore.Get() = %+v, want white winning on time after 1 move", r)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.games) != 0 {
+		t.Errorf("server has %d games loaded, want none after the flag fell", len(s.games))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var errNotFound = errors.New("game not found")
+
+// What a store keeps of a game, enough to replay it. Clocks aren't kept, so a
+// timed game loaded again goes on untimed.
+type record struct {
+	ID          string    `json:"id"`
+	StartFEN    string    `json:"start_fen"`
+	Moves       []string  `json:"moves"` // UCI
+	Created     time.Time `json:"created"`
+	TimeControl string    `json:"time_control,omitempty"`
+
+	// how the game ended, if it did
+	Winner string `json:"winner,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Keeps games between requests. Get returns errNotFound for games it doesn't
+// have, and both have to be safe to call from several goroutines.
+type store interface {
+	Get(id string) (record, error)
+	Put(r record) error
+}
+
+// Keeps games for as long as the server runs
+type memoryStore struct {
+	mu    sync.Mutex
+	games map[string]record
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{games: make(map[string]record)}
+}
+
+func (s *memoryStore) Get(id string) (record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.games[id]
+	if !ok {
+		return r, errNotFound
+	}
+	r.Moves = append([]string(nil), r.Moves...)
+	return r, nil
+}
+
+func (s *memoryStore) Put(r record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.Moves = append([]string(nil), r.Moves...)
+	s.games[r.ID] = r
+	return nil
+}
+
+// Keeps each game as a JSON file in a directory, so games outlive the server
+type dirStore struct {
+	dir string
+}
+
+func (s dirStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", errNotFound
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s dirStore) Get(id string) (record, error) {
+	var r record
+	path, err := s.path(id)
+	if err != nil {
+		return r, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, errNotFound
+	} else if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(data, &r)
+	return r, err
+}
+
+// Put writes the game to a temporary file first, so a crash can't leave it
+// half written
+func (s dirStore) Put(r record) error {
+	path, err := s.path(r.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
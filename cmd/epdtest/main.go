@@ -0,0 +1,111 @@
+// Command epdtest searches every position of an EPD test suite and reports
+// whether the move found matches the bm (best move) and am (avoid move)
+// operations of each.
+//
+//	epdtest [-depth n] [-time d] [-nodes n] suite.epd...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	depth := flag.Int("depth", 0, "search depth in plies per position, 0 for no limit")
+	moveTime := flag.Duration("time", 0, "search time per position, 1s if no limit is given")
+	nodes := flag.Int("nodes", 0, "nodes to search per position, 0 for no limit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-depth n] [-time d] [-nodes n] suite.epd...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	limits := chess.SearchLimits{Depth: *depth, MoveTime: *moveTime, Nodes: *nodes}
+	if limits == (chess.SearchLimits{}) {
+		limits.MoveTime = time.Second
+	}
+
+	var searcher chess.Searcher
+	solved, failed, skipped, totalNodes := 0, 0, 0, 0
+	start := time.Now()
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		records, err := chess.ReadEPD(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+
+		for n, epd := range records {
+			id := epd.ID()
+			if id == "" {
+				id = fmt.Sprintf("%s:%d", path, n+1)
+			}
+
+			bm, bmErr := epd.BestMoves()
+			am, amErr := epd.AvoidMoves()
+			if bmErr != nil || amErr != nil || len(bm)+len(am) == 0 {
+				fmt.Printf("%-20s skipped: no usable bm or am\n", id)
+				skipped++
+				continue
+			}
+
+			result := searcher.Search(epd.Board, limits)
+			totalNodes += result.Nodes
+
+			ok := len(bm) == 0 || contains(bm, result.Move)
+			if contains(am, result.Move) {
+				ok = false
+			}
+
+			expected := make([]string, 0, 2)
+			if ops, found := epd.Get("bm"); found {
+				expected = append(expected, "bm "+strings.Join(ops, " "))
+			}
+			if ops, found := epd.Get("am"); found {
+				expected = append(expected, "am "+strings.Join(ops, " "))
+			}
+
+			status := "solved"
+			if ok {
+				solved++
+			} else {
+				status = "FAILED"
+				failed++
+			}
+			fmt.Printf("%-20s %-6s %-7s %-16s depth %d, score %v\n", id, status, epd.Board.SAN(result.Move), strings.Join(expected, "; "), result.Depth, result.Score)
+		}
+	}
+
+	total := solved + failed
+	score := 0.0
+	if total > 0 {
+		score = 100 * float64(solved) / float64(total)
+	}
+	fmt.Printf("\nSolved %d of %d (%.1f%%), %d skipped\n", solved, total, score, skipped)
+	fmt.Fprintf(os.Stderr, "Time: %v, %d nodes\n", time.Since(start).Round(time.Millisecond), totalNodes)
+}
+
+func contains(moves []chess.Move, move chess.Move) bool {
+	for _, m := range moves {
+		if m.Matches(move) && m.PromotesTo == move.PromotesTo {
+			return true
+		}
+	}
+	return false
+}
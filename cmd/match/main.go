@@ -0,0 +1,185 @@
+// Command match plays games between two engines from a set of openings,
+// each opening once with either color, and reports the Elo difference
+// between them along with a sequential probability ratio test (SPRT).
+//
+//	match [-games n] [-openings file.epd] [-depth n] [-time d] [-nodes n] engine1 engine2
+//
+// An engine is "builtin" for the package's own search, optionally with its
+// own limits as in "builtin:depth=5,time=200ms", or the path of a UCI engine.
+// The match stops early once the SPRT accepts either hypothesis.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// Runs the match and returns the exit code, so the engines are closed
+// whichever way it ends
+func run() int {
+	games := flag.Int("games", 0, "number of games to play, twice the number of openings if 0")
+	openingsPath := flag.String("openings", "", "EPD file of opening positions, the starting position if empty")
+	depth := flag.Int("depth", 0, "search depth in plies per move, 0 for no limit")
+	moveTime := flag.Duration("time", 0, "search time per move, 100ms if no limit is given")
+	nodes := flag.Int("nodes", 0, "nodes to search per move, 0 for no limit")
+	maxPlies := flag.Int("max-plies", 400, "adjudicate games as draws after this many plies")
+	elo0 := flag.Float64("elo0", 0, "Elo difference of the SPRT null hypothesis")
+	elo1 := flag.Float64("elo1", 5, "Elo difference of the SPRT alternative hypothesis")
+	alpha := flag.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta := flag.Float64("beta", 0.05, "SPRT false negative rate")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] engine1 engine2\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		return 2
+	}
+
+	limits := chess.SearchLimits{Depth: *depth, MoveTime: *moveTime, Nodes: *nodes}
+	if limits == (chess.SearchLimits{}) {
+		limits.MoveTime = 100 * time.Millisecond
+	}
+
+	openings := []string{chess.StartingPosition().String()}
+	if *openingsPath != "" {
+		var err error
+		if openings, err = readOpenings(*openingsPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *games <= 0 {
+		*games = 2 * len(openings)
+	}
+
+	var players [2]player
+	for i := range players {
+		p, err := newPlayer(flag.Arg(i), limits)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer p.Close()
+		players[i] = p
+	}
+
+	lower, upper := sprtBounds(*alpha, *beta)
+	wins, losses, draws := 0, 0, 0
+	for n := 0; n < *games; n++ {
+		// each opening is played twice in a row, with the colors swapped
+		white, black := players[0], players[1]
+		if n%2 == 1 {
+			white, black = black, white
+		}
+
+		winner, reason, err := play(openings[n/2%len(openings)], white, black, *maxPlies)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "game %d: %v\n", n+1, err)
+			return 1
+		}
+		result := map[chess.SideColor]string{chess.White: "1-0", chess.Black: "0-1"}[winner]
+		if result == "" {
+			result = "1/2-1/2"
+		}
+		fmt.Printf("Game %d: %s vs %s: %s {%s}\n", n+1, white.Name(), black.Name(), result, reason)
+
+		switch {
+		case !winner.IsValid():
+			draws++
+		case (winner == chess.White) == (white == players[0]):
+			wins++
+		default:
+			losses++
+		}
+		fmt.Printf("Score of %s vs %s: %d - %d - %d [%.3f] %d\n", players[0].Name(), players[1].Name(), wins, losses, draws, (float64(wins)+float64(draws)/2)/float64(n+1), n+1)
+
+		if llr := sprtLLR(wins, losses, draws, *elo0, *elo1); llr <= lower || llr >= upper {
+			break
+		}
+	}
+
+	diff, margin := elo(wins, losses, draws)
+	fmt.Printf("\nElo difference: %.1f +/- %.1f\n", diff, margin)
+
+	llr := sprtLLR(wins, losses, draws, *elo0, *elo1)
+	verdict := "inconclusive"
+	if llr <= lower {
+		verdict = "H0 accepted"
+	} else if llr >= upper {
+		verdict = "H1 accepted"
+	}
+	fmt.Printf("SPRT: llr %.2f (%.2f, %.2f) [%g, %g]: %s\n", llr, lower, upper, *elo0, *elo1, verdict)
+	return 0
+}
+
+func readOpenings(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := chess.ReadEPD(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no openings", path)
+	}
+
+	openings := make([]string, len(records))
+	for i, epd := range records {
+		openings[i] = epd.Board.String()
+	}
+	return openings, nil
+}
+
+// Plays a game from the position and returns the winner, 0 for a draw, and
+// why the game ended
+func play(fen string, white, black player, maxPlies int) (chess.SideColor, string, error) {
+	board, err := chess.NewBoard(fen)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, p := range [...]player{white, black} {
+		if err := p.NewGame(); err != nil {
+			return 0, "", err
+		}
+	}
+
+	for ply := 0; ; ply++ {
+		if outcome := board.Outcome(); outcome.IsOver() {
+			return outcome.Winner, outcome.Reason.String(), nil
+		}
+		if ply >= maxPlies {
+			return 0, fmt.Sprintf("adjudicated after %d plies", maxPlies), nil
+		}
+
+		p, side := white, chess.White
+		if board.SideToMove == chess.Black {
+			p, side = black, chess.Black
+		}
+
+		move, err := p.Move(board)
+		if err == nil {
+			err = board.PlayMove(move)
+		}
+		if err != nil {
+			// an engine that crashes or plays an illegal move forfeits
+			fmt.Fprintln(os.Stderr, err)
+			return side ^ 0b11, p.Name() + " forfeits", nil
+		}
+	}
+}
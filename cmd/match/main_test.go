@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestEloDiff(t *testing.T) {
+	tests := []struct {
+		score, elo float64
+	}{
+		{0.5, 0},
+		{0.75, 190.848502},
+		{0.25, -190.848502},
+	}
+	for _, test := range tests {
+		if got := eloDiff(test.score); !near(got, test.elo) {
+			t.Errorf("eloDiff(%g) = %g, want %g", test.score, got, test.elo)
+		}
+		if got := expectedScore(test.elo); !near(got, test.score) {
+			t.Errorf("expectedScore(%g) = %g, want %g", test.elo, got, test.score)
+		}
+	}
+}
+
+func TestElo(t *testing.T) {
+	if mean, variance := scoreStats(0, 0, 0); mean != 0.5 || variance != 0 {
+		t.Errorf("scoreStats() of no games = %g, %g, want 0.5, 0", mean, variance)
+	}
+	if mean, variance := scoreStats(1, 1, 2); mean != 0.5 || variance != 0.125 {
+		t.Errorf("scoreStats(1, 1, 2) = %g, %g, want 0.5, 0.125", mean, variance)
+	}
+
+	if diff, margin := elo(60, 40, 0); !near(diff, 70.436504) || !near(margin, 70.572517) {
+		t.Errorf("elo(60, 40, 0) = %g +/- %g, want 70.436504 +/- 70.572517", diff, margin)
+	}
+	if _, margin := elo(10, 0, 0); !math.IsInf(margin, 1) {
+		t.Errorf("elo(10, 0, 0) margin = %g, want infinite for a perfect score", margin)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	lower, upper := sprtBounds(0.05, 0.05)
+	if !near(lower, -2.944439) || !near(upper, 2.944439) {
+		t.Errorf("sprtBounds(0.05, 0.05) = %g, %g, want -2.944439, 2.944439", lower, upper)
+	}
+
+	if got := sprtLLR(60, 40, 0, 0, 5); !near(got, 0.289010) {
+		t.Errorf("sprtLLR(60, 40, 0) = %g, want 0.289010", got)
+	}
+	if got := sprtLLR(0, 0, 10, 0, 5); got != 0 {
+		t.Errorf("sprtLLR() of draws only = %g, want 0", got)
+	}
+	if win, loss := sprtLLR(60, 40, 0, 0, 5), sprtLLR(40, 60, 0, 0, 5); win <= 0 || loss >= 0 {
+		t.Errorf("sprtLLR() = %g for more wins and %g for more losses, want positive and negative", win, loss)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+type player interface {
+	Name() string
+	NewGame() error
+	Move(board *chess.Board) (chess.Move, error)
+	Close() error
+}
+
+// Creates a player from an engine specification: "builtin", optionally with
+// limits such as "builtin:depth=4,time=100ms,nodes=5000", or the path of a
+// UCI engine
+func newPlayer(spec string, limits chess.SearchLimits) (player, error) {
+	if spec != "builtin" && !strings.HasPrefix(spec, "builtin:") {
+		return newUCIPlayer(spec, limits)
+	}
+
+	p := &builtinPlayer{name: spec, limits: limits}
+	if i := strings.IndexByte(spec, ':'); i != -1 {
+		for _, opt := range strings.Split(spec[i+1:], ",") {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s: option %q is not name=value", spec, opt)
+			}
+
+			var err error
+			switch kv[0] {
+			case "depth":
+				p.limits.Depth, err = strconv.Atoi(kv[1])
+			case "nodes":
+				p.limits.Nodes, err = strconv.Atoi(kv[1])
+			case "time":
+				p.limits.MoveTime, err = time.ParseDuration(kv[1])
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: option %q: %v", spec, kv[0], err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// The package's own search, run in process
+type builtinPlayer struct {
+	name     string
+	limits   chess.SearchLimits
+	searcher chess.Searcher
+}
+
+func (p *builtinPlayer) Name() string   { return p.name }
+func (p *builtinPlayer) NewGame() error { return nil }
+func (p *builtinPlayer) Close() error   { return nil }
+func (p *builtinPlayer) Move(board *chess.Board) (chess.Move, error) {
+	result := p.searcher.Search(board, p.limits)
+	if !result.Move.IsValid() {
+		return result.Move, fmt.Errorf("%s: no move found", p.name)
+	}
+	return result.Move, nil
+}
+
+// An external engine run as a subprocess and spoken to over UCI
+type uciPlayer struct {
+	engine *chess.UCIEngine
+	limits chess.SearchLimits
+}
+
+func newUCIPlayer(path string, limits chess.SearchLimits) (*uciPlayer, error) {
+	engine, err := chess.StartUCIEngine(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if engine.Name == "" {
+		engine.Name = path
+	}
+	return &uciPlayer{engine, limits}, nil
+}
+
+func (p *uciPlayer) Name() string   { return p.engine.Name }
+func (p *uciPlayer) NewGame() error { return p.engine.NewGame() }
+func (p *uciPlayer) Close() error   { return p.engine.Close() }
+func (p *uciPlayer) Move(board *chess.Board) (chess.Move, error) {
+	if err := p.engine.SetPosition(board); err != nil {
+		return chess.Move{}, fmt.Errorf("%s: %v", p.Name(), err)
+	}
+
+	result, err := p.engine.Go(p.limits, nil)
+	if err == nil && !result.Move.IsValid() {
+		err = fmt.Errorf("no move found")
+	}
+	if err != nil {
+		return chess.Move{}, fmt.Errorf("%s: %v", p.Name(), err)
+	}
+	return result.Move, nil
+}
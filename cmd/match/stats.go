@@ -0,0 +1,57 @@
+package main
+
+import "math"
+
+// Converts an expected score to an Elo difference and back, by the logistic
+// curve Elo ratings are defined with
+func eloDiff(score float64) float64 {
+	return -400 * math.Log10(1/score-1)
+}
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Returns the mean score per game and its variance
+func scoreStats(wins, losses, draws int) (mean, variance float64) {
+	n := float64(wins + losses + draws)
+	if n == 0 {
+		return 0.5, 0
+	}
+
+	mean = (float64(wins) + float64(draws)/2) / n
+	variance = (float64(wins)*math.Pow(1-mean, 2) + float64(draws)*math.Pow(0.5-mean, 2) + float64(losses)*math.Pow(mean, 2)) / n
+	return
+}
+
+// Returns the Elo difference the results suggest and the margin of its 95%
+// confidence interval
+func elo(wins, losses, draws int) (diff, margin float64) {
+	mean, variance := scoreStats(wins, losses, draws)
+	stderr := math.Sqrt(variance / float64(wins+losses+draws))
+
+	lo, hi := mean-1.96*stderr, mean+1.96*stderr
+	if lo <= 0 || hi >= 1 {
+		return eloDiff(mean), math.Inf(1)
+	}
+	return eloDiff(mean), (eloDiff(hi) - eloDiff(lo)) / 2
+}
+
+// Returns the log-likelihood ratio of the hypothesis that the Elo difference
+// is elo1 against it being elo0, approximating the per-game scores as normally
+// distributed like fishtest does
+func sprtLLR(wins, losses, draws int, elo0, elo1 float64) float64 {
+	mean, variance := scoreStats(wins, losses, draws)
+	if variance == 0 {
+		return 0
+	}
+
+	n := float64(wins + losses + draws)
+	s0, s1 := expectedScore(elo0), expectedScore(elo1)
+	return (s1 - s0) * (2*mean - s0 - s1) * n / (2 * variance)
+}
+
+// Returns the bounds an SPRT's log-likelihood ratio is tested against: H0 is
+// accepted below lower and H1 above upper
+func sprtBounds(alpha, beta float64) (lower, upper float64) {
+	return math.Log(beta / (1 - alpha)), math.Log((1 - beta) / alpha)
+}
@@ -0,0 +1,83 @@
+// Command mkbook builds a Polyglot opening book from PGN game collections.
+//
+//	mkbook [-ply n] [-min-games n] -o book.bin games.pgn...
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	ply := flag.Int("ply", 20, "number of plies of each game to include, 0 for all")
+	minGames := flag.Int("min-games", 3, "leave out moves played in fewer games")
+	out := flag.String("o", "book.bin", "output book file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-ply n] [-min-games n] [-o book.bin] games.pgn...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	builder := chess.NewBookBuilder(*ply)
+	builder.MinGames = *minGames
+
+	games, skipped := 0, 0
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		pgn := chess.NewPGNReader(f)
+		for {
+			game, err := pgn.Next()
+			var pgnErr *chess.PGNError
+			if err == io.EOF {
+				break
+			} else if errors.As(err, &pgnErr) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				os.Exit(1)
+			}
+
+			if err := builder.AddGame(game); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			}
+			games++
+		}
+		f.Close()
+	}
+
+	book := builder.Book()
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, err := book.WriteTo(f); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d games read, %d skipped, %d book entries written to %s\n", games, skipped, book.Len(), *out)
+}
@@ -0,0 +1,72 @@
+// Command perft counts the leaf nodes of the move tree of a position and
+// prints them per root move, in the same format as Stockfish's "go perft".
+//
+//	perft [-threads n] [-stats] depth [fen]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	threads := flag.Int("threads", runtime.NumCPU(), "number of goroutines to split root moves between")
+	stats := flag.Bool("stats", false, "print capture, castle, promotion and check counts")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-threads n] [-stats] depth [fen]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	depth, err := strconv.Atoi(flag.Arg(0))
+	if err != nil || depth < 1 {
+		fmt.Fprintf(os.Stderr, "invalid depth %q\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	board := chess.StartingPosition()
+	if flag.NArg() > 1 {
+		fen := strings.Join(flag.Args()[1:], " ")
+		if board, err = chess.NewBoard(fen); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %q\n", err, fen)
+			os.Exit(1)
+		}
+	}
+
+	start := time.Now()
+	total, divide := board.Perft(depth, *threads)
+	elapsed := time.Since(start)
+
+	sort.Slice(divide, func(i, j int) bool {
+		return divide[i].Move.UCI() < divide[j].Move.UCI()
+	})
+	for _, d := range divide {
+		fmt.Printf("%s: %d\n", d.Move.UCI(), d.Nodes)
+	}
+
+	fmt.Printf("\nNodes searched: %d\n", total.Nodes)
+	if *stats {
+		fmt.Printf("Captures: %d\n", total.Captures)
+		fmt.Printf("E.p.: %d\n", total.EnPassant)
+		fmt.Printf("Castles: %d\n", total.Castles)
+		fmt.Printf("Promotions: %d\n", total.Promotions)
+		fmt.Printf("Checks: %d\n", total.Checks)
+		fmt.Printf("Discovery checks: %d\n", total.DiscoveredChecks)
+		fmt.Printf("Double checks: %d\n", total.DoubleChecks)
+		fmt.Printf("Checkmates: %d\n", total.Checkmates)
+	}
+	fmt.Fprintf(os.Stderr, "Time: %v (%.0f nps)\n", elapsed.Round(time.Millisecond), float64(total.Nodes)/elapsed.Seconds())
+}
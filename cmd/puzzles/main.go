@@ -0,0 +1,82 @@
+// Command puzzles searches PGN game collections for puzzles and writes one
+// per line as the FEN, the UCI solution and the motif tags, separated by
+// commas.
+//
+//	puzzles [-depth n] [-time d] [-nodes n] [-winning cp] [-swing cp] [-max-moves n] games.pgn...
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kananb/chess"
+)
+
+func main() {
+	depth := flag.Int("depth", 0, "search depth in plies per position, 0 for no limit")
+	moveTime := flag.Duration("time", 0, "search time per position, 0 for no limit")
+	nodes := flag.Int("nodes", 0, "nodes to search per position, depth 6 if no limit is given")
+	winning := flag.Int("winning", 300, "centipawns an advantage needs to be winning")
+	swing := flag.Int("swing", 300, "centipawns the move before the puzzle has to lose")
+	maxMoves := flag.Int("max-moves", 3, "solver moves in a solution at most")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-depth n] [-time d] [-nodes n] [-winning cp] [-swing cp] [-max-moves n] games.pgn...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	limits := chess.SearchLimits{Depth: *depth, MoveTime: *moveTime, Nodes: *nodes}
+	if limits == (chess.SearchLimits{}) {
+		limits.Depth = 6
+	}
+	finder := chess.NewPuzzleFinder(limits)
+	finder.Winning, finder.Swing, finder.MaxMoves = *winning, *swing, *maxMoves
+
+	games, skipped, found := 0, 0, 0
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		pgn := chess.NewPGNReader(f)
+		for {
+			game, err := pgn.Next()
+			var pgnErr *chess.PGNError
+			if err == io.EOF {
+				break
+			} else if errors.As(err, &pgnErr) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				os.Exit(1)
+			}
+
+			puzzles, err := finder.Find(game)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				skipped++
+				continue
+			}
+			for _, puzzle := range puzzles {
+				fmt.Println(puzzle)
+			}
+			games++
+			found += len(puzzles)
+		}
+		f.Close()
+	}
+
+	fmt.Fprintf(os.Stderr, "%d games read, %d skipped, %d puzzles found\n", games, skipped, found)
+}
@@ -0,0 +1,112 @@
+package chess
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Opening classification, with the name split into the opening and the
+// variation the same way as the PGN Opening and Variation tags
+type Opening struct {
+	ECO       string
+	Name      string
+	Variation string
+	Ply       int // the number of moves into the game the opening was recognized
+}
+
+// Main lines of the ECO codes as tab separated code, name and movetext
+//
+//go:embed eco.tsv
+var ecoTable string
+
+var (
+	ecoOnce      sync.Once
+	ecoPositions map[uint64]Opening
+)
+
+// Indexes the ECO table by position, so transpositions into a line are
+// recognized however the moves were ordered
+func loadECO() {
+	ecoPositions = make(map[uint64]Opening)
+
+	for n, line := range strings.Split(strings.TrimSpace(ecoTable), "\n")[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			panic(fmt.Sprintf("eco.tsv line %d: want 3 fields, got %d", n+2, len(fields)))
+		}
+
+		opening := Opening{ECO: fields[0], Name: fields[1]}
+		if i := strings.Index(opening.Name, ": "); i != -1 {
+			opening.Name, opening.Variation = fields[1][:i], fields[1][i+2:]
+		}
+
+		board := StartingPosition()
+		for _, san := range strings.Fields(fields[2]) {
+			if strings.HasSuffix(san, ".") {
+				continue
+			}
+			if _, err := board.Play(san); err != nil {
+				panic(fmt.Sprintf("eco.tsv line %d: %v", n+2, err))
+			}
+		}
+
+		if key := board.PolyglotKey(); ecoPositions[key].ECO == "" {
+			ecoPositions[key] = opening
+		}
+	}
+}
+
+// ClassifyOpening finds the deepest position of the board's history that is
+// in the ECO table. Ply in the result is how many moves into the history it
+// was reached.
+func ClassifyOpening(board *Board) (Opening, bool) {
+	ecoOnce.Do(loadECO)
+
+	replay := board.Clone()
+	for len(replay.history) > 0 {
+		replay.UnmakeMove()
+	}
+
+	var found Opening
+	ok := false
+	for ply, state := range board.history {
+		replay.MakeMove(state.Move)
+
+		if opening, known := ecoPositions[replay.PolyglotKey()]; known {
+			found, ok = opening, true
+			found.Ply = ply + 1
+		}
+	}
+	return found, ok
+}
+
+// Sets the ECO, Opening and Variation tags of the game, or removes them if
+// its opening isn't in the table
+func (g *Game) ClassifyOpening() error {
+	board, err := g.Board()
+	if err != nil {
+		return err
+	}
+
+	opening, ok := ClassifyOpening(board)
+	if !ok {
+		delete(g.Tags, "ECO")
+		delete(g.Tags, "Opening")
+		delete(g.Tags, "Variation")
+		return nil
+	}
+
+	if g.Tags == nil {
+		g.Tags = make(map[string]string)
+	}
+	g.Tags["ECO"] = opening.ECO
+	g.Tags["Opening"] = opening.Name
+	if opening.Variation != "" {
+		g.Tags["Variation"] = opening.Variation
+	} else {
+		delete(g.Tags, "Variation")
+	}
+	return nil
+}
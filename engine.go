@@ -1,6 +1,9 @@
 package chess
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 var slideDirections = [...]struct{ f, r int }{
 	{1, 1}, {1, -1}, {-1, -1}, {-1, 1},
@@ -139,9 +142,13 @@ func (board *Board) kingMoves(pieces []int) []Move {
 
 		checkCastle := func(side CastleSide, dir int) {
 			between, to := Coord{from.File + 1*dir, from.Rank}, Coord{from.File + 2*dir, from.Rank}
-			if board.CastleRights.Can(piece.Color, side) && !board.At(between).IsValid() && !board.At(to).IsValid() {
-				moveSet = append(moveSet, Move{from, to, MoveFlags{Moves: King, CastlesTo: side}})
+			if !board.CastleRights.Can(piece.Color, side) || board.At(between).IsValid() || board.At(to).IsValid() {
+				return
 			}
+			if side == Queenside && board.At(Coord{2, from.Rank}).IsValid() { // the rook also passes the b-file
+				return
+			}
+			moveSet = append(moveSet, Move{from, to, MoveFlags{Moves: King, CastlesTo: side}})
 		}
 		if (from.Rank == 1 || from.Rank == 8) && from.File == 5 {
 			checkCastle(Kingside, 1)
@@ -154,51 +161,142 @@ func (board *Board) kingMoves(pieces []int) []Move {
 	return moveSet
 }
 
-func (board *Board) InCheck(side SideColor) bool {
-	var from Coord
+func (board *Board) kingSquare(side SideColor) Coord {
 	for i := 0; i < len(board.squares); i++ {
 		if board.squares[i].Color == side && board.squares[i].Name == King {
-			from = indexCoord(i)
-			break
+			return indexCoord(i)
 		}
 	}
+	return Coord{0, 0}
+}
+
+// Appends the squares of the pieces of the given color attacking the target
+// square to found, stopping early once found is full
+func (board *Board) attackers(target Coord, color SideColor, found []Coord) []Coord {
+	if !target.IsValid() {
+		return found
+	}
 
 	for i, dir := range slideDirections {
 		for off := 1; ; off++ {
-			to := Coord{from.File + dir.f*off, from.Rank + dir.r*off}
-			if !to.IsValid() || board.At(to).Color == side {
+			from := Coord{target.File + dir.f*off, target.Rank + dir.r*off}
+			if !from.IsValid() {
 				break
 			}
 
-			target := board.At(to).Name
-			if target == Queen || (i < 4 && target == Bishop) || (i >= 4 && target == Rook) {
-				return true
-			} else if target.IsValid() {
-				break
+			piece := board.At(from)
+			if !piece.IsValid() {
+				continue
 			}
+			if piece.Color == color && (piece.Name == Queen || (i < 4 && piece.Name == Bishop) || (i >= 4 && piece.Name == Rook)) {
+				if found = append(found, from); len(found) == cap(found) {
+					return found
+				}
+			}
+			break
 		}
 	}
 
 	for _, off := range knightOffsets {
-		to := Coord{from.File + off.f, from.Rank + off.r}
-		if to.IsValid() && board.At(to).Color != side && board.At(to).Name == Knight {
-			return true
+		from := Coord{target.File + off.f, target.Rank + off.r}
+		if piece := board.At(from); piece != nil && piece.Color == color && piece.Name == Knight {
+			if found = append(found, from); len(found) == cap(found) {
+				return found
+			}
 		}
 	}
 
-	dir := 1
-	if side == Black {
-		dir = -1
+	for x := -1; x < 2; x++ {
+		for y := -1; y < 2; y++ {
+			from := Coord{target.File + x, target.Rank + y}
+			if piece := board.At(from); piece != nil && piece.Color == color && piece.Name == King && from != target {
+				if found = append(found, from); len(found) == cap(found) {
+					return found
+				}
+			}
+		}
+	}
+
+	dir := -1 // pawns attack towards the opposing side
+	if color == Black {
+		dir = 1
 	}
-	pawnSquares := [...]Coord{{from.File - 1, from.Rank + dir}, {from.File + 1, from.Rank + dir}}
-	for _, square := range pawnSquares {
-		piece := board.At(square)
-		if piece != nil && piece.Name == Pawn && piece.Color != side {
-			return true
+	for _, from := range [...]Coord{{target.File - 1, target.Rank + dir}, {target.File + 1, target.Rank + dir}} {
+		if piece := board.At(from); piece != nil && piece.Color == color && piece.Name == Pawn {
+			found = append(found, from)
 		}
 	}
 
-	return false
+	return found
+}
+
+// Attackers returns the squares of the pieces of the given color attacking
+// the target square, whatever stands on it
+func (board *Board) Attackers(target Coord, color SideColor) []Coord {
+	return board.attackers(target, color, make([]Coord, 0, 32))
+}
+
+// Attacks returns the squares the piece on from attacks, empty or holding a
+// piece of either color
+func (board *Board) Attacks(from Coord) []Coord {
+	piece := board.At(from)
+	if piece == nil || !piece.IsValid() {
+		return nil
+	}
+
+	attacked := make([]Coord, 0, 27)
+	switch piece.Name {
+	case Pawn:
+		dir := 1
+		if piece.Color == Black {
+			dir = -1
+		}
+		for _, to := range [...]Coord{{from.File - 1, from.Rank + dir}, {from.File + 1, from.Rank + dir}} {
+			if to.IsValid() {
+				attacked = append(attacked, to)
+			}
+		}
+	case Knight:
+		for _, off := range knightOffsets {
+			if to := (Coord{from.File + off.f, from.Rank + off.r}); to.IsValid() {
+				attacked = append(attacked, to)
+			}
+		}
+	case King:
+		for x := -1; x < 2; x++ {
+			for y := -1; y < 2; y++ {
+				if to := (Coord{from.File + x, from.Rank + y}); to.IsValid() && to != from {
+					attacked = append(attacked, to)
+				}
+			}
+		}
+	default:
+		di, df := 0, 8
+		if piece.Name == Bishop {
+			df = 4
+		} else if piece.Name == Rook {
+			di = 4
+		}
+		for d := di; d < df; d++ {
+			for off := 1; ; off++ {
+				to := Coord{from.File + slideDirections[d].f*off, from.Rank + slideDirections[d].r*off}
+				if !to.IsValid() {
+					break
+				}
+				attacked = append(attacked, to)
+				if board.At(to).IsValid() {
+					break
+				}
+			}
+		}
+	}
+	return attacked
+}
+
+// A side without a king is never in check, Board.Validate rejects such positions
+func (board *Board) InCheck(side SideColor) bool {
+	var buf [1]Coord
+	return len(board.attackers(board.kingSquare(side), side^0b11, buf[:0])) > 0
 }
 func (board *Board) InCheckmate() bool {
 	return board.InCheck(board.SideToMove) && len(board.Moves()) == 0
@@ -272,45 +370,139 @@ func (board *Board) Moves() []Move {
 
 	return moveSet
 }
-func (board *Board) CountMoves(depth int) (int, []int) {
+
+// Perft statistics, counted at the leaves of the move tree the same way as
+// the tables on the Chess Programming Wiki
+type PerftStats struct {
+	Nodes            int
+	Captures         int
+	EnPassant        int
+	Castles          int
+	Promotions       int
+	Checks           int
+	DiscoveredChecks int
+	DoubleChecks     int
+	Checkmates       int
+}
+
+func (s *PerftStats) add(o PerftStats) {
+	s.Nodes += o.Nodes
+	s.Captures += o.Captures
+	s.EnPassant += o.EnPassant
+	s.Castles += o.Castles
+	s.Promotions += o.Promotions
+	s.Checks += o.Checks
+	s.DiscoveredChecks += o.DiscoveredChecks
+	s.DoubleChecks += o.DoubleChecks
+	s.Checkmates += o.Checkmates
+}
+
+func (board *Board) CountMoves(depth int) PerftStats {
 	if depth <= 0 {
-		return 1, nil
+		return PerftStats{Nodes: 1}
 	}
 
-	moves := board.Moves()
-	// if depth == 1 {
-	// 	return len(moves), []int{}
-	// }
-
-	count, breakdown := 0, make([]int, 8)
-	for _, move := range moves {
-		if actual := board.MakeMove(move); !actual.IsValid() {
-			panic(fmt.Sprintf("invalid move generated: %v", actual))
-		}
+	stats := PerftStats{}
+	for _, move := range board.Moves() {
+		stats.add(board.countMove(move, depth))
+	}
+	return stats
+}
 
-		if move.Captures.IsValid() {
-			breakdown[0]++
-		}
-		if move.IsEnPassant {
-			breakdown[1]++
-		}
-		if move.CastlesTo.IsValid() {
-			breakdown[2]++
-		}
-		if move.PromotesTo.IsValid() {
-			breakdown[3]++
-		}
+// Plays the move and counts the leaves below it, the move itself being the
+// first of depth plies
+func (board *Board) countMove(move Move, depth int) (stats PerftStats) {
+	if actual := board.MakeMove(move); !actual.IsValid() {
+		panic(fmt.Sprintf("invalid move generated: %v", actual))
+	}
 
-		amount, parts := board.CountMoves(depth - 1)
-		count += amount
-		for i := 0; i < len(parts); i++ {
-			breakdown[i] += parts[i]
-		}
+	if depth > 1 {
+		stats = board.CountMoves(depth - 1)
+	} else {
+		stats = board.leafStats(move)
+	}
+
+	board.UnmakeMove()
+	return
+}
+
+// Classifies the move that was just played to reach a leaf
+func (board *Board) leafStats(move Move) PerftStats {
+	stats := PerftStats{Nodes: 1}
+
+	if move.Captures.IsValid() || move.IsEnPassant {
+		stats.Captures++
+	}
+	if move.IsEnPassant {
+		stats.EnPassant++
+	}
+	if move.CastlesTo.IsValid() {
+		stats.Castles++
+	}
+	if move.PromotesTo.IsValid() {
+		stats.Promotions++
+	}
 
-		board.UnmakeMove()
+	var buf [2]Coord
+	checkers := board.attackers(board.kingSquare(board.SideToMove), board.SideToMove^0b11, buf[:0])
+	if len(checkers) == 0 {
+		return stats
 	}
 
-	return count, breakdown
+	stats.Checks++
+	if len(checkers) > 1 {
+		stats.DoubleChecks++
+	} else if checkers[0] != move.To && !move.CastlesTo.IsValid() { // castling checks come from the moved rook
+		stats.DiscoveredChecks++
+	}
+	if len(board.Moves()) == 0 {
+		stats.Checkmates++
+	}
+
+	return stats
+}
+
+type PerftDivide struct {
+	Move Move
+	PerftStats
+}
+
+// Perft counts the leaves at the given depth and breaks the total down by
+// root move. Root moves are split between up to threads goroutines, each
+// working on its own copy of the board.
+func (board *Board) Perft(depth, threads int) (PerftStats, []PerftDivide) {
+	if depth <= 0 {
+		return PerftStats{Nodes: 1}, nil
+	}
+	if threads < 1 {
+		threads = 1
+	}
+
+	moves := board.Moves()
+	divide := make([]PerftDivide, len(moves))
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for t := 0; t < threads && t < len(moves); t++ {
+		wg.Add(1)
+		go func(child *Board) {
+			defer wg.Done()
+			for i := range jobs {
+				divide[i] = PerftDivide{moves[i], child.countMove(moves[i], depth)}
+			}
+		}(board.Clone())
+	}
+	for i := range moves {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	stats := PerftStats{}
+	for _, d := range divide {
+		stats.add(d.PerftStats)
+	}
+	return stats, divide
 }
 
 func (board *Board) updateState(move Move) {
@@ -325,14 +517,8 @@ func (board *Board) updateState(move Move) {
 	if move.Moves == King {
 		board.CastleRights.Disallow(board.SideToMove, Kingside)
 		board.CastleRights.Disallow(board.SideToMove, Queenside)
-	} else if move.Moves == Rook || move.Captures == Rook {
-		var rook Coord
-		if move.Moves == Rook {
-			rook = move.From
-		} else {
-			rook = move.To
-		}
-
+	}
+	for _, rook := range [...]Coord{move.From, move.To} { // a rook leaving or being captured on its corner
 		switch rook {
 		case Coord{1, 1}:
 			board.CastleRights.Disallow(White, Queenside)
@@ -349,7 +535,7 @@ func (board *Board) updateState(move Move) {
 	if board.SideToMove == White {
 		board.FullmoveCounter++
 	}
-	if move.Moves == Pawn || board.At(move.To).IsValid() {
+	if move.Moves == Pawn || move.Captures.IsValid() {
 		board.HalfmoveClock = 0
 	} else {
 		board.HalfmoveClock++
@@ -450,3 +636,29 @@ func (board *Board) UnmakeMove() Move {
 
 	return state.Move
 }
+
+// Plays a move given in SAN after checking that it is legal
+func (board *Board) Play(san string) (Move, error) {
+	move, err := NewMove(san, board)
+	if err != nil {
+		return move, err
+	}
+	return board.legalMove(move, san)
+}
+
+// Plays the move after checking that it is legal, unlike MakeMove which
+// accepts any from and to squares
+func (board *Board) PlayMove(move Move) error {
+	_, err := board.legalMove(move, move.String())
+	return err
+}
+
+func (board *Board) legalMove(move Move, notation string) (Move, error) {
+	for _, legal := range board.Moves() {
+		if legal.Matches(move) && legal.PromotesTo == move.PromotesTo {
+			legal.OffersDraw = move.OffersDraw
+			return board.MakeMove(legal), nil
+		}
+	}
+	return move, &MoveError{notation, ErrIllegalMove}
+}
@@ -0,0 +1,247 @@
+package chess
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type EPDOperation struct {
+	Opcode   string
+	Operands []string
+}
+
+// An Extended Position Description record: the first four fields of a FEN
+// string followed by operations such as "bm Nf3;" or "id \"WAC.001\";"
+type EPD struct {
+	Board      *Board
+	Operations []EPDOperation
+}
+
+// Parses an EPD record. The halfmove clock and fullmove counter come from
+// the hmvc and fmvn operations if present.
+func ParseEPD(line string) (*EPD, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, &FENError{Value: line}
+	}
+
+	board, err := NewBoard(strings.Join(fields[:4], " ") + " 0 1")
+	if err != nil {
+		return nil, err
+	}
+	epd := &EPD{Board: board}
+
+	// the operations start after the fourth field, wherever its whitespace ends
+	rest := strings.TrimSpace(line)
+	for i := 0; i < 4; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[len(fields[i]):]
+	}
+	if epd.Operations, err = parseEPDOperations(rest); err != nil {
+		return nil, err
+	}
+
+	for _, opcode := range [...]string{"acd", "ce", "hmvc", "fmvn"} {
+		n, ok, err := epd.intOperand(opcode)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && opcode == "hmvc" {
+			board.HalfmoveClock = n
+		} else if ok && opcode == "fmvn" {
+			board.FullmoveCounter = n
+		}
+	}
+
+	return epd, nil
+}
+
+func parseEPDOperations(s string) ([]EPDOperation, error) {
+	ops := make([]EPDOperation, 0, 4)
+	op := EPDOperation{}
+	token := bytes.Buffer{}
+	quoted := false
+
+	endToken := func() {
+		if token.Len() == 0 && !quoted {
+			return
+		}
+		if op.Opcode == "" {
+			op.Opcode = token.String()
+		} else {
+			op.Operands = append(op.Operands, token.String())
+		}
+		token.Reset()
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end == -1 || op.Opcode == "" {
+				return nil, fmt.Errorf("invalid EPD operation %q: unterminated string", strings.TrimSpace(s[i:]))
+			}
+			token.WriteString(s[i+1 : i+1+end])
+			quoted = true
+			endToken()
+			quoted = false
+			i += end + 1
+		case c == ';':
+			endToken()
+			if op.Opcode == "" {
+				return nil, fmt.Errorf("invalid EPD operation: missing opcode")
+			}
+			ops = append(ops, op)
+			op = EPDOperation{}
+		case c == ' ' || c == '\t':
+			endToken()
+		default:
+			token.WriteByte(c)
+		}
+	}
+
+	if endToken(); op.Opcode != "" {
+		return nil, fmt.Errorf("invalid EPD operation %q: missing semicolon", op.Opcode)
+	}
+	return ops, nil
+}
+
+// Reads one EPD record per line, skipping blank lines
+func ReadEPD(r io.Reader) ([]*EPD, error) {
+	records := make([]*EPD, 0, 64)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		epd, err := ParseEPD(scanner.Text())
+		if err != nil {
+			return records, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, epd)
+	}
+
+	return records, scanner.Err()
+}
+
+// Returns the operands of the first operation with the opcode
+func (e *EPD) Get(opcode string) ([]string, bool) {
+	for _, op := range e.Operations {
+		if op.Opcode == opcode {
+			return op.Operands, true
+		}
+	}
+	return nil, false
+}
+
+// Replaces the operands of the operation with the opcode, adding it if needed
+func (e *EPD) Set(opcode string, operands ...string) {
+	for i, op := range e.Operations {
+		if op.Opcode == opcode {
+			e.Operations[i].Operands = operands
+			return
+		}
+	}
+	e.Operations = append(e.Operations, EPDOperation{opcode, operands})
+}
+
+func (e *EPD) ID() string {
+	id, _ := e.Get("id")
+	return strings.Join(id, " ")
+}
+func (e *EPD) Comment() string {
+	c0, _ := e.Get("c0")
+	return strings.Join(c0, " ")
+}
+
+// The best moves, bm
+func (e *EPD) BestMoves() ([]Move, error) {
+	return e.moves("bm", false)
+}
+
+// The moves to avoid, am
+func (e *EPD) AvoidMoves() ([]Move, error) {
+	return e.moves("am", false)
+}
+
+// The predicted variation, pv, with each move following the one before it
+func (e *EPD) PV() ([]Move, error) {
+	return e.moves("pv", true)
+}
+
+// The analysis depth, acd
+func (e *EPD) Depth() (int, bool) {
+	n, ok, err := e.intOperand("acd")
+	return n, ok && err == nil
+}
+
+// The centipawn evaluation, ce, from the side to move's point of view
+func (e *EPD) Eval() (int, bool) {
+	n, ok, err := e.intOperand("ce")
+	return n, ok && err == nil
+}
+
+func (e *EPD) intOperand(opcode string) (int, bool, error) {
+	operands, ok := e.Get(opcode)
+	if !ok {
+		return 0, false, nil
+	}
+	if len(operands) != 1 {
+		return 0, false, fmt.Errorf("invalid EPD operation %q: want one operand", opcode)
+	}
+
+	n, err := strconv.Atoi(operands[0])
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid EPD operation %q: %q is not a number", opcode, operands[0])
+	}
+	return n, true, nil
+}
+
+func (e *EPD) moves(opcode string, sequence bool) ([]Move, error) {
+	operands, _ := e.Get(opcode)
+	board := e.Board.Clone()
+
+	moves := make([]Move, 0, len(operands))
+	for _, san := range operands {
+		move, err := board.Play(san)
+		if err != nil {
+			return moves, err
+		}
+		if !sequence {
+			board.UnmakeMove()
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+// Formats the record with the position's halfmove clock and fullmove counter
+// left out, as they belong in the hmvc and fmvn operations
+func (e *EPD) String() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(strings.Join(strings.Fields(e.Board.String())[:4], " "))
+
+	for _, op := range e.Operations {
+		buf.WriteByte(' ')
+		buf.WriteString(op.Opcode)
+
+		str := op.Opcode == "id" || (len(op.Opcode) == 2 && op.Opcode[0] == 'c' && op.Opcode[1] >= '0' && op.Opcode[1] <= '9')
+		for _, operand := range op.Operands {
+			if str || operand == "" || strings.ContainsAny(operand, " \t;") {
+				operand = `"` + operand + `"`
+			}
+			buf.WriteString(" " + operand)
+		}
+		buf.WriteByte(';')
+	}
+
+	return buf.String()
+}
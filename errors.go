@@ -0,0 +1,53 @@
+package chess
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidNotation = errors.New("invalid move notation")
+	ErrAmbiguousMove   = errors.New("ambiguous move")
+	ErrIllegalMove     = errors.New("illegal move")
+	ErrNoSuchPiece     = errors.New("no such piece")
+
+	ErrInvalidPosition = errors.New("invalid position")
+
+	ErrNotInTablebase = errors.New("position not in the tablebases")
+
+	ErrTimeout     = errors.New("out of time")
+	ErrGameOver    = errors.New("game is over")
+	ErrNoDrawOffer = errors.New("no draw offer to answer")
+)
+
+// Error for a FEN string that can't be parsed. Field names the part of the
+// string at fault, or is empty if the string as a whole is malformed.
+type FENError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *FENError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid FEN string %q", e.Value)
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("invalid FEN %s %q", e.Field, e.Value)
+	}
+	return fmt.Sprintf("invalid FEN %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// Error for a move that can't be read or played, wrapping one of the Err*
+// move errors
+type MoveError struct {
+	Move string
+	Err  error
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Move)
+}
+func (e *MoveError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,339 @@
+package chess
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventMove EventType = iota + 1
+	EventClock
+	EventDrawOffer
+	EventDrawDeclined
+	EventEnd
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventMove:
+		return "move"
+	case EventClock:
+		return "clock"
+	case EventDrawOffer:
+		return "draw offer"
+	case EventDrawDeclined:
+		return "draw declined"
+	case EventEnd:
+		return "end"
+	default:
+		return ""
+	}
+}
+
+// Something that happened in a live game. Only the fields of its type are
+// set, besides Ply and FEN.
+type Event struct {
+	Type EventType
+	Ply  int    // moves played so far
+	FEN  string // the position after the event
+
+	Move Move      // EventMove
+	SAN  string    // EventMove
+	Side SideColor // who moved, offered a draw or declined it
+
+	// the time left, in a game with a clock, for EventMove, EventEnd and
+	// EventClock, which comes when a flag falls
+	White, Black time.Duration
+
+	Outcome Outcome // EventEnd
+}
+
+// A game being played, which players act on and anyone can follow through
+// the events it sends its subscribers. Moves are checked against the clock,
+// if there is one, and a flag falling ends the game as soon as it happens by
+// the clock's time.
+type LiveGame struct {
+	mu        sync.Mutex
+	board     *Board
+	clock     *Clock
+	outcome   Outcome   // of a game ended off the board
+	drawOffer SideColor // the side with a draw offer standing
+	ended     bool
+	events    []Event
+	subs      map[chan Event]bool
+	disarm    chan struct{} // closed to stop waiting for the flag to fall
+}
+
+// NewLiveGame starts a game from a copy of the board, timed by the clock
+// unless it's nil. The clock starts with the first move.
+func NewLiveGame(board *Board, clock *Clock) *LiveGame {
+	return &LiveGame{board: board.Clone(), clock: clock, subs: make(map[chan Event]bool)}
+}
+
+// Board returns a copy of the game's position
+func (g *LiveGame) Board() *Board {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.board.Clone()
+}
+
+func (g *LiveGame) Outcome() Outcome {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.end()
+	return g.currentOutcome()
+}
+
+func (g *LiveGame) currentOutcome() Outcome {
+	switch {
+	case g.outcome.IsOver():
+		return g.outcome
+	case g.clock != nil:
+		return g.clock.Outcome(g.board)
+	default:
+		return g.board.Outcome()
+	}
+}
+
+// Remaining returns the time each side has left, and false if the game
+// isn't timed
+func (g *LiveGame) Remaining() (white, black time.Duration, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.clock == nil {
+		return 0, 0, false
+	}
+	return g.clock.Remaining(White), g.clock.Remaining(Black), true
+}
+
+// DrawOffer returns the side whose draw offer stands, 0 if there's none
+func (g *LiveGame) DrawOffer() SideColor {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.drawOffer
+}
+
+// Events returns the events of the game so far
+func (g *LiveGame) Events() []Event {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Event(nil), g.events...)
+}
+
+// Subscribe returns a channel that gets the events of the game so far, then
+// each new one, and is closed after the game ends. A subscriber that falls
+// too far behind is dropped, its channel closed early. Calling cancel drops
+// it too.
+func (g *LiveGame) Subscribe() (events <-chan Event, cancel func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ch := make(chan Event, len(g.events)+64)
+	for _, e := range g.events {
+		ch <- e
+	}
+	if g.end() {
+		close(ch)
+		return ch, func() {}
+	}
+
+	g.subs[ch] = true
+	return ch, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.subs[ch] {
+			delete(g.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Sends the event to every subscriber; g.mu has to be held
+func (g *LiveGame) publish(e Event) {
+	e.Ply, e.FEN = len(g.board.history), g.board.String()
+	g.events = append(g.events, e)
+
+	for ch := range g.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(g.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (g *LiveGame) clockEvent(t EventType) Event {
+	e := Event{Type: t}
+	if g.clock != nil {
+		e.White, e.Black = g.clock.Remaining(White), g.clock.Remaining(Black)
+	}
+	return e
+}
+
+// Ends the game if it's over, closing the subscriptions, and reports whether
+// it is; g.mu has to be held
+func (g *LiveGame) end() bool {
+	if g.ended {
+		return true
+	}
+	outcome := g.currentOutcome()
+	if !outcome.IsOver() {
+		return false
+	}
+
+	g.ended = true
+	g.disarmTimer()
+	if g.clock != nil {
+		g.clock.Stop()
+	}
+	g.drawOffer = 0
+
+	if g.clock != nil && g.clock.Flagged().IsValid() {
+		g.publish(g.clockEvent(EventClock))
+	}
+	e := g.clockEvent(EventEnd)
+	e.Outcome = outcome
+	g.publish(e)
+	for ch := range g.subs {
+		delete(g.subs, ch)
+		close(ch)
+	}
+	return true
+}
+
+// Play plays the move for the side to move. A move that comes after its
+// side ran out of time isn't played, and ends the game with ErrTimeout.
+func (g *LiveGame) Play(move Move) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.end() {
+		return ErrGameOver
+	}
+
+	side := g.board.SideToMove
+	san := g.board.SAN(move)
+	var err error
+	if g.clock != nil {
+		err = g.clock.Play(g.board, move)
+	} else {
+		err = g.board.PlayMove(move)
+	}
+	if err != nil {
+		g.end() // if the flag fell
+		return err
+	}
+
+	// moving answers the other side's draw offer
+	if g.drawOffer == side^0b11 {
+		g.drawOffer = 0
+		g.publish(Event{Type: EventDrawDeclined, Side: side})
+	}
+
+	e := g.clockEvent(EventMove)
+	e.Move, e.SAN, e.Side = g.board.history[len(g.board.history)-1].Move, san, side
+	g.publish(e)
+
+	if !g.end() && g.clock != nil {
+		g.armTimer()
+	}
+	return nil
+}
+
+// Waits in the background for the side to move to run out of time; g.mu has
+// to be held
+func (g *LiveGame) armTimer() {
+	g.disarmTimer()
+	disarm := make(chan struct{})
+	g.disarm = disarm
+
+	expired := g.clock.after(g.clock.Remaining(g.board.SideToMove))
+	go func() {
+		select {
+		case <-expired:
+			g.timeUp(disarm)
+		case <-disarm:
+		}
+	}()
+}
+
+func (g *LiveGame) disarmTimer() {
+	if g.disarm != nil {
+		close(g.disarm)
+		g.disarm = nil
+	}
+}
+
+// Ends the game if the flag has fallen, or waits again if a delay kept it up
+func (g *LiveGame) timeUp(disarm chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.disarm != disarm {
+		return // the side moved in time
+	}
+	g.disarm = nil
+	if !g.end() {
+		g.armTimer()
+	}
+}
+
+// OfferDraw offers a draw on the side's behalf, which the other side can
+// answer with AnswerDraw or by moving
+func (g *LiveGame) OfferDraw(side SideColor) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.end() {
+		return ErrGameOver
+	}
+	if g.drawOffer != side {
+		g.drawOffer = side
+		g.publish(Event{Type: EventDrawOffer, Side: side})
+	}
+	return nil
+}
+
+// AnswerDraw accepts or declines the other side's draw offer
+func (g *LiveGame) AnswerDraw(side SideColor, accept bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.end() {
+		return ErrGameOver
+	}
+	if g.drawOffer != side^0b11 {
+		return ErrNoDrawOffer
+	}
+
+	g.drawOffer = 0
+	if !accept {
+		g.publish(Event{Type: EventDrawDeclined, Side: side})
+		return nil
+	}
+	g.outcome = Outcome{Reason: EndAgreement}
+	g.end()
+	return nil
+}
+
+// Resign ends the game as a loss for the side
+func (g *LiveGame) Resign(side SideColor) error {
+	return g.Adjudicate(Outcome{side ^ 0b11, EndResignation})
+}
+
+// Adjudicate ends the game with the outcome, as an arbiter would, or as it
+// had ended before when reloading a game
+func (g *LiveGame) Adjudicate(outcome Outcome) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.end() {
+		return ErrGameOver
+	}
+	g.outcome = outcome
+	g.end()
+	return nil
+}
@@ -0,0 +1,171 @@
+package chess
+
+// A forced mate found by FindMate
+type MateSolution struct {
+	Moves     int    // the mate is in this many moves
+	Solutions []Move // every first move that mates in Moves, one if the mate is unique
+	PV        []Move // the first solution against the longest defence
+}
+
+func (m MateSolution) Unique() bool {
+	return len(m.Solutions) == 1
+}
+func (m MateSolution) Score() Score {
+	return MateIn(m.Moves)
+}
+
+// FindMate proves the shortest forced mate for the side to move in at most
+// maxMoves moves, and every first move that forces it. The search is depth
+// first over all moves but the last, which has to give check, so it's exact
+// but only fast for short mates. Repetitions and the fifty move rule are
+// ignored.
+func FindMate(board *Board, maxMoves int) (MateSolution, bool) {
+	f := mateFinder{
+		board:   board.Clone(),
+		mates:   make(map[uint64]int),
+		noMates: make(map[uint64]int),
+	}
+
+	moves := f.board.Moves()
+	for n := 1; n <= maxMoves && len(moves) > 0; n++ {
+		var solutions []Move
+		for _, move := range moves {
+			f.board.MakeMove(move)
+			if f.defend(n - 1) {
+				solutions = append(solutions, move)
+			}
+			f.board.UnmakeMove()
+		}
+
+		if len(solutions) > 0 {
+			return MateSolution{n, solutions, f.line(solutions[0], n)}, true
+		}
+	}
+	return MateSolution{}, false
+}
+
+type mateFinder struct {
+	board *Board
+
+	// the fewest moves the side to move of a position is proven to mate in,
+	// and the most it's proven not to, by PolyglotKey
+	mates, noMates map[uint64]int
+}
+
+// Whether the side to move mates in at most n moves
+func (f *mateFinder) attack(n int) bool {
+	if n <= 0 {
+		return false
+	}
+
+	key := f.board.PolyglotKey()
+	if m, ok := f.mates[key]; ok && m <= n {
+		return true
+	}
+	if m, ok := f.noMates[key]; ok && m >= n {
+		return false
+	}
+
+	// checks first, as they leave the fewest replies, and only checks for
+	// the mating move itself
+	var quiet []Move
+	found := false
+	for _, move := range f.board.Moves() {
+		f.board.MakeMove(move)
+		check := f.board.InCheck(f.board.SideToMove)
+		found = check && f.defend(n-1)
+		f.board.UnmakeMove()
+
+		if found {
+			break
+		}
+		if !check && n > 1 {
+			quiet = append(quiet, move)
+		}
+	}
+	for i := 0; !found && i < len(quiet); i++ {
+		f.board.MakeMove(quiet[i])
+		found = f.defend(n - 1)
+		f.board.UnmakeMove()
+	}
+
+	if found {
+		f.mates[key] = n
+	} else if n > f.noMates[key] {
+		f.noMates[key] = n
+	}
+	return found
+}
+
+// Whether the side to move is mated, or is mated in at most n moves whatever
+// it plays
+func (f *mateFinder) defend(n int) bool {
+	moves := f.board.Moves()
+	if len(moves) == 0 {
+		return f.board.InCheck(f.board.SideToMove)
+	}
+	if n <= 0 {
+		return false
+	}
+
+	for _, move := range moves {
+		f.board.MakeMove(move)
+		mated := f.attack(n)
+		f.board.UnmakeMove()
+
+		if !mated {
+			return false
+		}
+	}
+	return true
+}
+
+// The fewest moves, up to n, the side to move mates in
+func (f *mateFinder) fastest(n int) int {
+	for k := 1; k < n; k++ {
+		if f.attack(k) {
+			return k
+		}
+	}
+	return n
+}
+
+// The line of a mate in n starting with first, with the defence that holds
+// out longest and the quickest mate against it
+func (f *mateFinder) line(first Move, n int) []Move {
+	pv := []Move{first}
+	f.board.MakeMove(first)
+
+	for n > 1 {
+		replies := f.board.Moves()
+		if len(replies) == 0 {
+			break
+		}
+
+		reply, left := replies[0], 0
+		for _, move := range replies {
+			f.board.MakeMove(move)
+			if k := f.fastest(n - 1); k > left {
+				reply, left = move, k
+			}
+			f.board.UnmakeMove()
+		}
+		f.board.MakeMove(reply)
+		pv = append(pv, reply)
+		n = left
+
+		for _, move := range f.board.Moves() {
+			f.board.MakeMove(move)
+			if f.defend(n - 1) {
+				pv = append(pv, move)
+				break
+			}
+			f.board.UnmakeMove()
+		}
+	}
+
+	for range pv {
+		f.board.UnmakeMove()
+	}
+	return pv
+}
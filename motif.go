@@ -0,0 +1,333 @@
+package chess
+
+import "strings"
+
+type MotifType int
+
+// The squares of a Motif for each type
+const (
+	Fork               MotifType = iota + 1 // the forking piece, then the pieces it attacks
+	Pin                                     // the pinning piece, the pinned piece and the one behind it
+	Skewer                                  // the skewering piece, the piece in front and the one behind it
+	DiscoveredAttack                        // the unmasked piece, the piece that moved and the one attacked
+	DoubleCheck                             // the checking pieces
+	BackRankWeakness                        // the king that can't leave its back rank
+	HangingPiece                            // the piece
+	OverloadedDefender                      // the defender, then the pieces only it defends
+)
+
+func (t MotifType) String() string {
+	switch t {
+	case Fork:
+		return "fork"
+	case Pin:
+		return "pin"
+	case Skewer:
+		return "skewer"
+	case DiscoveredAttack:
+		return "discovered attack"
+	case DoubleCheck:
+		return "double check"
+	case BackRankWeakness:
+		return "back rank weakness"
+	case HangingPiece:
+		return "hanging piece"
+	case OverloadedDefender:
+		return "overloaded defender"
+	default:
+		return ""
+	}
+}
+
+// A tactical pattern on the board, to the advantage of Side
+type Motif struct {
+	Type    MotifType
+	Side    SideColor
+	Squares []Coord
+}
+
+// String returns the type followed by the squares, e.g. "fork c7 e8 a8"
+func (m Motif) String() string {
+	parts := []string{m.Type.String()}
+	for _, c := range m.Squares {
+		parts = append(parts, c.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (m Motif) equal(o Motif) bool {
+	if m.Type != o.Type || m.Side != o.Side || len(m.Squares) != len(o.Squares) {
+		return false
+	}
+	for i := range m.Squares {
+		if m.Squares[i] != o.Squares[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Motifs returns the motifs of the position for either side. They're found
+// by static rules on what attacks and defends what, without searching, so
+// they point at tactics rather than prove them:
+//   - a fork attacks two pieces other than pawns that are the king, worth
+//     more than the attacker or undefended
+//   - a pin lines a sliding piece up with a piece and a more valuable one
+//     behind it, a skewer with a more valuable piece in front of one worth
+//     more than the slider or undefended
+//   - a hanging piece is attacked and undefended, or attacked by a piece
+//     worth less
+//   - an overloaded defender is the only defender of two attacked pieces
+//   - a back rank weakness is a king on its back rank with every square in
+//     front of it blocked by its own pieces or attacked, and the rank open on
+//     one side, while the other side has a rook or queen
+func (board *Board) Motifs() []Motif {
+	var motifs []Motif
+	motifs = append(motifs, board.forks()...)
+	motifs = append(motifs, board.pins()...)
+	if m, ok := board.doubleCheck(); ok {
+		motifs = append(motifs, m)
+	}
+	motifs = append(motifs, board.backRankWeaknesses()...)
+	motifs = append(motifs, board.hangingPieces()...)
+	motifs = append(motifs, board.overloadedDefenders()...)
+	return motifs
+}
+
+// MoveMotifs returns the motifs the move creates for the side playing it:
+// those of the position after it that weren't there before, and the attacks
+// it discovers
+func (board *Board) MoveMotifs(move Move) []Motif {
+	side := board.SideToMove
+	before := board.Motifs()
+	after := board.Child(move)
+
+	var motifs []Motif
+	for _, m := range after.Motifs() {
+		if m.Side != side {
+			continue
+		}
+
+		found := false
+		for _, b := range before {
+			if found = m.equal(b); found {
+				break
+			}
+		}
+		if !found {
+			motifs = append(motifs, m)
+		}
+	}
+
+	// a slider that saw the moved piece now sees past it
+	for _, i := range after.pieceIndices(side, Bishop, Rook, Queen) {
+		from := indexCoord(i)
+		if from == move.To || !containsCoord(board.Attacks(from), move.From) {
+			continue
+		}
+
+		old := board.Attacks(from)
+		for _, to := range after.Attacks(from) {
+			if target := after.At(to); target.IsValid() && target.Color != side && !containsCoord(old, to) && after.threatens(from, to) {
+				motifs = append(motifs, Motif{DiscoveredAttack, side, []Coord{from, move.To, to}})
+			}
+		}
+	}
+	return motifs
+}
+
+// Values for weighing motifs, the king above everything
+func motifValue(name PieceName) int {
+	if name == King {
+		return 10000
+	}
+	return pieceValues[name]
+}
+
+func containsCoord(coords []Coord, c Coord) bool {
+	for _, o := range coords {
+		if o == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (board *Board) defended(c Coord) bool {
+	var buf [1]Coord
+	return len(board.attackers(c, board.At(c).Color, buf[:0])) > 0
+}
+
+// Whether the piece on from attacking the one on to is a real threat
+func (board *Board) threatens(from, to Coord) bool {
+	target := board.At(to)
+	return target.Name == King || motifValue(target.Name) > motifValue(board.At(from).Name) || !board.defended(to)
+}
+
+func (board *Board) forks() (motifs []Motif) {
+	for i, piece := range board.squares {
+		if !piece.IsValid() {
+			continue
+		}
+
+		from := indexCoord(i)
+		squares := []Coord{from}
+		for _, to := range board.Attacks(from) {
+			if target := board.At(to); target.IsValid() && target.Color != piece.Color && target.Name != Pawn && board.threatens(from, to) {
+				squares = append(squares, to)
+			}
+		}
+		if len(squares) > 2 {
+			motifs = append(motifs, Motif{Fork, piece.Color, squares})
+		}
+	}
+	return
+}
+
+func (board *Board) pins() (motifs []Motif) {
+	for i, piece := range board.squares {
+		if piece.Name != Bishop && piece.Name != Rook && piece.Name != Queen {
+			continue
+		}
+
+		di, df := 0, 8
+		if piece.Name == Bishop {
+			df = 4
+		} else if piece.Name == Rook {
+			di = 4
+		}
+
+		from := indexCoord(i)
+		for d := di; d < df; d++ {
+			// the first two pieces along the line
+			var line [2]Coord
+			n := 0
+			for off := 1; n < 2; off++ {
+				c := Coord{from.File + slideDirections[d].f*off, from.Rank + slideDirections[d].r*off}
+				if !c.IsValid() {
+					break
+				}
+				if board.At(c).IsValid() {
+					line[n] = c
+					n++
+				}
+			}
+			if n < 2 {
+				continue
+			}
+
+			front, back := board.At(line[0]), board.At(line[1])
+			if front.Color == piece.Color || back.Color != front.Color {
+				continue
+			}
+			if motifValue(back.Name) > motifValue(front.Name) {
+				motifs = append(motifs, Motif{Pin, piece.Color, []Coord{from, line[0], line[1]}})
+			} else if motifValue(front.Name) > motifValue(back.Name) && back.Name != Pawn && (motifValue(back.Name) > motifValue(piece.Name) || !board.defended(line[1])) {
+				motifs = append(motifs, Motif{Skewer, piece.Color, []Coord{from, line[0], line[1]}})
+			}
+		}
+	}
+	return
+}
+
+func (board *Board) doubleCheck() (Motif, bool) {
+	checkers := board.Attackers(board.kingSquare(board.SideToMove), board.SideToMove^0b11)
+	if len(checkers) < 2 {
+		return Motif{}, false
+	}
+	return Motif{DoubleCheck, board.SideToMove ^ 0b11, checkers}, true
+}
+
+func (board *Board) backRankWeaknesses() (motifs []Motif) {
+	for _, side := range [...]SideColor{White, Black} {
+		opponent := side ^ 0b11
+		king := board.kingSquare(side)
+		backRank, forward := 1, 2
+		if side == Black {
+			backRank, forward = 8, 7
+		}
+		if king.Rank != backRank || len(board.pieceIndices(opponent, Rook, Queen)) == 0 {
+			continue
+		}
+
+		escapes := false
+		for f := king.File - 1; f <= king.File+1; f++ {
+			c := Coord{f, forward}
+			if c.IsValid() && board.At(c).Color != side && len(board.Attackers(c, opponent)) == 0 {
+				escapes = true
+			}
+		}
+
+		// a rank closed off by the king's own pieces can't be checked along
+		open := false
+		for _, dir := range [...]int{-1, 1} {
+			c := Coord{king.File + dir, backRank}
+			for c.IsValid() && !board.At(c).IsValid() {
+				c.File += dir
+			}
+			if !c.IsValid() || board.At(c).Color != side {
+				open = true
+			}
+		}
+		if !escapes && open {
+			motifs = append(motifs, Motif{BackRankWeakness, opponent, []Coord{king}})
+		}
+	}
+	return
+}
+
+// The least valuable attacker of the square, 0 if there's none
+func (board *Board) leastAttacker(c Coord, color SideColor) (least int) {
+	for _, from := range board.Attackers(c, color) {
+		if v := motifValue(board.At(from).Name); least == 0 || v < least {
+			least = v
+		}
+	}
+	return
+}
+
+func (board *Board) hangingPieces() (motifs []Motif) {
+	for i, piece := range board.squares {
+		if !piece.IsValid() || piece.Name == King {
+			continue
+		}
+
+		c := indexCoord(i)
+		least := board.leastAttacker(c, piece.Color^0b11)
+		if least != 0 && (!board.defended(c) || least < motifValue(piece.Name)) {
+			motifs = append(motifs, Motif{HangingPiece, piece.Color ^ 0b11, []Coord{c}})
+		}
+	}
+	return
+}
+
+func (board *Board) overloadedDefenders() (motifs []Motif) {
+	duties := make(map[Coord][]Coord)
+	var defenders []Coord // in the order found
+	for i, piece := range board.squares {
+		if !piece.IsValid() || piece.Name == King {
+			continue
+		}
+
+		// pieces attacked by something worth less hang however well defended
+		c := indexCoord(i)
+		least := board.leastAttacker(c, piece.Color^0b11)
+		if least == 0 || least < motifValue(piece.Name) {
+			continue
+		}
+		if defending := board.Attackers(c, piece.Color); len(defending) == 1 {
+			d := defending[0]
+			if duties[d] == nil {
+				defenders = append(defenders, d)
+			}
+			duties[d] = append(duties[d], c)
+		}
+	}
+
+	for _, d := range defenders {
+		if len(duties[d]) > 1 {
+			motifs = append(motifs, Motif{OverloadedDefender, board.At(d).Color ^ 0b11, append([]Coord{d}, duties[d]...)})
+		}
+	}
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 type Coord struct {
@@ -67,7 +68,7 @@ var moveexp = regexp.MustCompile(`^(?P<Move>(?P<Piece>[NBRQK]?)(?:(?P<FileSpecif
 func NewMove(san string, board *Board) (move Move, err error) {
 	matches := moveexp.FindStringSubmatch(san)
 	if matches == nil {
-		return move, fmt.Errorf("invalid move")
+		return move, &MoveError{san, ErrInvalidNotation}
 	}
 
 	// Draw offer
@@ -87,7 +88,7 @@ func NewMove(san string, board *Board) (move Move, err error) {
 		} else if matches[i] == "#" {
 			move.Check = Checkmate
 		} else {
-			panic(fmt.Sprintf("invalid check notation %q, want +|++|#", matches[i]))
+			return move, &MoveError{san, ErrInvalidNotation}
 		}
 	}
 
@@ -106,7 +107,7 @@ func NewMove(san string, board *Board) (move Move, err error) {
 			move.CastlesTo = Queenside
 			move.To = Coord{3, rank}
 		} else {
-			panic(fmt.Sprintf("invalid castle notation %q", matches[i]))
+			return move, &MoveError{san, ErrInvalidNotation}
 		}
 
 		move.Moves = King
@@ -116,8 +117,8 @@ func NewMove(san string, board *Board) (move Move, err error) {
 	// Pawn promotion
 	if i := moveexp.SubexpIndex("Promotion"); i != -1 && matches[i] != "" {
 		pieceName := NewPieceName(string(matches[i][0]))
-		if pieceName == 0 || pieceName >= King {
-			panic(fmt.Sprintf("invalid promotion notation %q, want [NBRQ]", matches[i]))
+		if !pieceName.IsValidPromoteType() {
+			return move, &MoveError{san, ErrInvalidNotation}
 		}
 
 		move.PromotesTo = pieceName
@@ -126,12 +127,12 @@ func NewMove(san string, board *Board) (move Move, err error) {
 	// Destination
 	if i := moveexp.SubexpIndex("Destination"); i != -1 && matches[i] != "" {
 		if dest := NewCoord(matches[i]); dest.File == 0 || dest.Rank == 0 {
-			panic(fmt.Sprintf("invalid destination notation %q, want [a-h][1-8]", matches[i]))
+			return move, &MoveError{san, ErrInvalidNotation}
 		} else {
 			move.To = dest
 		}
 	} else {
-		return move, fmt.Errorf("Move must include destination square")
+		return move, &MoveError{san, ErrInvalidNotation}
 	}
 
 	// Takes
@@ -144,7 +145,7 @@ func NewMove(san string, board *Board) (move Move, err error) {
 	if i := moveexp.SubexpIndex("Piece"); i != -1 && matches[i] != "" {
 		move.Moves = NewPieceName(string(matches[i][0]))
 		if !move.Moves.IsValid() {
-			panic(fmt.Sprintf("invalid move piece notation %q, want [NBRQK]?", matches[i]))
+			return move, &MoveError{san, ErrInvalidNotation}
 		}
 	} else {
 		move.Moves = Pawn
@@ -161,27 +162,46 @@ func NewMove(san string, board *Board) (move Move, err error) {
 	move.From = Coord{file, rank}
 
 	if file == -1 || rank == -1 {
-		candidates := board.PseudoMoves(move.Moves)
-
-		for _, c := range candidates {
-			if c.To == move.To && (file == -1 || c.From.File == file) && (rank == -1 || c.From.Rank == rank) {
-				if move.From.IsValid() {
-					return move, fmt.Errorf("Move is ambiguous")
+		// only legal moves count, so a pinned piece doesn't make a move ambiguous
+		for _, c := range board.Moves() {
+			if c.Moves == move.Moves && c.To == move.To && (file == -1 || c.From.File == file) && (rank == -1 || c.From.Rank == rank) {
+				if move.From.IsValid() && move.From != c.From { // promotions share their squares
+					return move, &MoveError{san, ErrAmbiguousMove}
 				}
 				move.From = c.From
 			}
 		}
 	}
 
-	if !move.From.IsValid() {
-		return move, fmt.Errorf("invalid move")
-	} else if board.At(move.From).Name != move.Moves {
-		return move, fmt.Errorf("wrong piece type")
+	if !move.From.IsValid() && len(board.pieceIndices(board.SideToMove, move.Moves)) == 0 {
+		return move, &MoveError{san, ErrNoSuchPiece}
+	} else if !move.From.IsValid() {
+		return move, &MoveError{san, ErrIllegalMove}
+	} else if piece := board.At(move.From); piece.Name != move.Moves || piece.Color != board.SideToMove {
+		return move, &MoveError{san, ErrNoSuchPiece}
 	}
 
 	return
 }
 
+// NewUCIMove reads a move in the long algebraic form used by UCI and returns
+// the legal move of the board it stands for
+func NewUCIMove(uci string, board *Board) (Move, error) {
+	if len(uci) < 4 || len(uci) > 5 || !NewCoord(uci[:2]).IsValid() || !NewCoord(uci[2:4]).IsValid() {
+		return Move{}, &MoveError{uci, ErrInvalidNotation}
+	}
+	if len(uci) == 5 && !strings.ContainsRune("nbrq", rune(uci[4])) {
+		return Move{}, &MoveError{uci, ErrInvalidNotation}
+	}
+
+	for _, m := range board.Moves() {
+		if m.UCI() == uci {
+			return m, nil
+		}
+	}
+	return Move{}, &MoveError{uci, ErrIllegalMove}
+}
+
 func (m Move) Matches(move Move) bool {
 	return m.To == move.To && m.From == move.From
 }
@@ -189,6 +209,19 @@ func (m Move) IsValid() bool {
 	return m.To.IsValid() && m.From.IsValid()
 }
 
+// UCI returns the move in long algebraic form as used by the UCI protocol,
+// e.g. e2e4, e1g1 or e7e8q
+func (m Move) UCI() string {
+	if !m.IsValid() {
+		return ""
+	}
+
+	uci := m.From.String() + m.To.String()
+	if m.PromotesTo.IsValid() {
+		uci += strings.ToLower(m.PromotesTo.Abbreviation())
+	}
+	return uci
+}
 func (m Move) String() string {
 	if !m.IsValid() {
 		return ""
@@ -226,3 +259,99 @@ func (m Move) String() string {
 
 	return buf.String()
 }
+
+// SAN returns the move in standard algebraic notation, e.g. Nbd7, exd6, e8=Q+
+// or O-O-O#, or an empty string if it isn't legal on the board
+func (board *Board) SAN(move Move) string {
+	legal := board.Moves()
+
+	found := false
+	for _, m := range legal {
+		if m.Matches(move) && m.PromotesTo == move.PromotesTo {
+			move, found = m, true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+
+	buf := bytes.Buffer{}
+	switch {
+	case move.CastlesTo == Kingside:
+		buf.WriteString("O-O")
+	case move.CastlesTo == Queenside:
+		buf.WriteString("O-O-O")
+	default:
+		captures := move.Captures.IsValid() || move.IsEnPassant
+		if move.Moves == Pawn {
+			if captures {
+				buf.WriteByte(move.From.String()[0])
+			}
+		} else {
+			buf.WriteString(move.Moves.Abbreviation())
+
+			// name the from file if it tells the pieces apart, else the rank,
+			// else both
+			ambiguous, sameFile, sameRank := false, false, false
+			for _, m := range legal {
+				if m.Moves == move.Moves && m.To == move.To && m.From != move.From {
+					ambiguous = true
+					sameFile = sameFile || m.From.File == move.From.File
+					sameRank = sameRank || m.From.Rank == move.From.Rank
+				}
+			}
+			if ambiguous && (!sameFile || sameRank) {
+				buf.WriteByte(move.From.String()[0])
+			}
+			if ambiguous && sameFile {
+				buf.WriteByte(move.From.String()[1])
+			}
+		}
+
+		if captures {
+			buf.WriteByte('x')
+		}
+		buf.WriteString(move.To.String())
+		if move.PromotesTo.IsValid() {
+			buf.WriteString("=" + move.PromotesTo.Abbreviation())
+		}
+	}
+
+	after := board.Child(move)
+	if after.InCheck(after.SideToMove) {
+		if len(after.Moves()) == 0 {
+			buf.WriteByte('#')
+		} else {
+			buf.WriteByte('+')
+		}
+	}
+	return buf.String()
+}
+
+// SANLine renders moves played one after another from the position in SAN,
+// numbered as in PGN movetext, e.g. "12... Qxd5 13. Nc3 Qa5". Rendering stops
+// at the first move that isn't legal.
+func (board *Board) SANLine(moves []Move) string {
+	replay := board.Clone()
+	buf := bytes.Buffer{}
+
+	for i, move := range moves {
+		san := replay.SAN(move)
+		if san == "" {
+			break
+		}
+
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		if replay.SideToMove == White {
+			fmt.Fprintf(&buf, "%d. ", replay.FullmoveCounter)
+		} else if i == 0 {
+			fmt.Fprintf(&buf, "%d... ", replay.FullmoveCounter)
+		}
+		buf.WriteString(san)
+		replay.PlayMove(move)
+	}
+	return buf.String()
+}
@@ -0,0 +1,148 @@
+package chess
+
+type EndReason int
+
+const (
+	EndCheckmate EndReason = iota + 1
+	EndStalemate
+	EndFiftyMoves
+	EndInsufficientMaterial
+	EndRepetition
+	EndTimeout
+	EndAgreement
+	EndResignation
+)
+
+func (r EndReason) String() string {
+	switch r {
+	case EndCheckmate:
+		return "checkmate"
+	case EndStalemate:
+		return "stalemate"
+	case EndFiftyMoves:
+		return "fifty move rule"
+	case EndInsufficientMaterial:
+		return "insufficient material"
+	case EndRepetition:
+		return "threefold repetition"
+	case EndTimeout:
+		return "timeout"
+	case EndAgreement:
+		return "agreement"
+	case EndResignation:
+		return "resignation"
+	default:
+		return ""
+	}
+}
+
+// How a game ended. The zero value is a game still in progress, and a draw
+// has a reason but no winner.
+type Outcome struct {
+	Winner SideColor
+	Reason EndReason
+}
+
+func (o Outcome) IsOver() bool {
+	return o.Reason != 0
+}
+func (o Outcome) IsDraw() bool {
+	return o.Reason != 0 && !o.Winner.IsValid()
+}
+
+// Result returns the outcome as a PGN game result
+func (o Outcome) Result() string {
+	switch {
+	case o.Winner == White:
+		return "1-0"
+	case o.Winner == Black:
+		return "0-1"
+	case o.Reason != 0:
+		return "1/2-1/2"
+	default:
+		return "*"
+	}
+}
+
+// Outcome reports whether the game has ended by the rules, with the fifty
+// move rule and threefold repetition applied as automatic draws
+func (board *Board) Outcome() Outcome {
+	if len(board.Moves()) == 0 {
+		if board.InCheck(board.SideToMove) {
+			return Outcome{board.SideToMove ^ 0b11, EndCheckmate}
+		}
+		return Outcome{Reason: EndStalemate}
+	}
+
+	switch {
+	case board.InsufficientMaterial():
+		return Outcome{Reason: EndInsufficientMaterial}
+	case board.HalfmoveClock >= 100:
+		return Outcome{Reason: EndFiftyMoves}
+	case board.Repetitions() >= 3:
+		return Outcome{Reason: EndRepetition}
+	}
+	return Outcome{}
+}
+
+// InsufficientMaterial reports whether neither side has the material left
+// to checkmate: kings with at most one minor piece between them, or with
+// bishops all on squares of the same color
+func (board *Board) InsufficientMaterial() bool {
+	knights, bishops := 0, 0
+	var bishopSquares [2]int // light, dark
+
+	for i, piece := range board.squares {
+		switch piece.Name {
+		case Pawn, Rook, Queen:
+			return false
+		case Knight:
+			knights++
+		case Bishop:
+			bishops++
+			bishopSquares[(i/8+i%8+1)%2]++
+		}
+	}
+
+	if knights+bishops <= 1 {
+		return true
+	}
+	return knights == 0 && (bishopSquares[0] == 0 || bishopSquares[1] == 0)
+}
+
+// MatingMaterial reports whether the side has anything to checkmate with
+// besides its king. Like most servers, a lone knight or bishop counts as
+// nothing when its side's opponent runs out of time, even though helpmates
+// exist with it.
+func (board *Board) MatingMaterial(side SideColor) bool {
+	minors := 0
+	for _, piece := range board.squares {
+		if piece.Color != side {
+			continue
+		}
+		switch piece.Name {
+		case Pawn, Rook, Queen:
+			return true
+		case Knight, Bishop:
+			minors++
+		}
+	}
+	return minors > 1
+}
+
+// Repetitions counts how many times the current position has occurred in
+// the board's history, itself included. Positions only repeat since the last
+// capture or pawn move, so the search stops at the halfmove clock.
+func (board *Board) Repetitions() int {
+	key := board.PolyglotKey()
+	count := 1
+
+	replay := board.Clone()
+	for i := 0; i < board.HalfmoveClock && len(replay.history) > 0; i++ {
+		replay.UnmakeMove()
+		if i%2 == 1 && replay.PolyglotKey() == key {
+			count++
+		}
+	}
+	return count
+}
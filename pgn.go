@@ -0,0 +1,407 @@
+package chess
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A game read from PGN. Moves are played from the position in the FEN tag,
+// or the standard starting position if there isn't one.
+type Game struct {
+	Tags   map[string]string
+	Moves  []Move
+	Result string
+
+	Annotations map[int]Annotation // by the index of the move they follow
+}
+
+// A numeric annotation glyph, e.g. 2 for a poor move written ?, and a comment
+// following a move
+type Annotation struct {
+	NAG     int
+	Comment string
+}
+
+// The move suffixes that stand for the first six NAGs
+var nagSuffixes = [...]string{1: "!", 2: "?", 3: "!!", 4: "??", 5: "!?", 6: "?!"}
+
+func (g *Game) annotate(i int, fn func(*Annotation)) {
+	if i < 0 {
+		return // comments before the first move aren't kept
+	}
+	if g.Annotations == nil {
+		g.Annotations = make(map[int]Annotation)
+	}
+	a := g.Annotations[i]
+	fn(&a)
+	g.Annotations[i] = a
+}
+
+// NewGame returns the game played on the board so far, its moves taken from
+// the board's history, with a FEN tag unless it started from the standard
+// starting position
+func NewGame(board *Board) *Game {
+	start := board.Clone()
+	moves := make([]Move, len(start.history))
+	for i := len(moves) - 1; i >= 0; i-- {
+		moves[i] = start.UnmakeMove()
+	}
+
+	game := &Game{Tags: make(map[string]string), Moves: moves, Result: board.Outcome().Result()}
+	if fen := start.String(); fen != StartingPosition().String() {
+		game.Tags["SetUp"], game.Tags["FEN"] = "1", fen
+	}
+	return game
+}
+
+func (g *Game) StartingPosition() (*Board, error) {
+	if fen, ok := g.Tags["FEN"]; ok {
+		return NewBoard(fen)
+	}
+	return StartingPosition(), nil
+}
+
+// Replays the game and returns the final position
+func (g *Game) Board() (*Board, error) {
+	board, err := g.StartingPosition()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, move := range g.Moves {
+		if err := board.PlayMove(move); err != nil {
+			return nil, err
+		}
+	}
+	return board, nil
+}
+
+var pgnSevenTags = [...]string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+// WriteTo writes the game as PGN in export format: the seven tag roster,
+// with ? for the tags missing, then the other tags by name, and the movetext
+// wrapped to 80 columns
+func (g *Game) WriteTo(w io.Writer) (int64, error) {
+	board, err := g.StartingPosition()
+	if err != nil {
+		return 0, err
+	}
+
+	result := g.Result
+	if result == "" {
+		result = "*"
+	}
+
+	buf := bytes.Buffer{}
+	writeTag := func(name, value string) {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+		fmt.Fprintf(&buf, "[%s \"%s\"]\n", name, value)
+	}
+	for _, name := range pgnSevenTags {
+		value, ok := g.Tags[name]
+		switch {
+		case name == "Result":
+			value = result
+		case !ok && name == "Date":
+			value = "????.??.??"
+		case !ok:
+			value = "?"
+		}
+		writeTag(name, value)
+	}
+	others := make([]string, 0, len(g.Tags))
+	for name := range g.Tags {
+		if name != "Event" && name != "Site" && name != "Date" && name != "Round" && name != "White" && name != "Black" && name != "Result" {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		writeTag(name, g.Tags[name])
+	}
+	buf.WriteByte('\n')
+
+	var tokens []string
+	for i, move := range g.Moves {
+		if board.SideToMove == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", board.FullmoveCounter))
+		} else if i == 0 || g.Annotations[i-1].Comment != "" {
+			tokens = append(tokens, fmt.Sprintf("%d...", board.FullmoveCounter))
+		}
+
+		san := board.SAN(move)
+		if san == "" {
+			return 0, &MoveError{move.UCI(), ErrIllegalMove}
+		}
+		note := g.Annotations[i]
+		if note.NAG > 0 && note.NAG < len(nagSuffixes) {
+			san += nagSuffixes[note.NAG]
+		}
+		tokens = append(tokens, san)
+		if note.NAG >= len(nagSuffixes) {
+			tokens = append(tokens, fmt.Sprintf("$%d", note.NAG))
+		}
+		if note.Comment != "" {
+			comment := strings.ReplaceAll(note.Comment, "}", ")")
+			tokens = append(tokens, strings.Fields("{"+comment+"}")...)
+		}
+		board.MakeMove(move)
+	}
+	tokens = append(tokens, result)
+
+	line := 0
+	for _, tok := range tokens {
+		if line > 0 && line+1+len(tok) > 79 {
+			buf.WriteByte('\n')
+			line = 0
+		} else if line > 0 {
+			buf.WriteByte(' ')
+			line++
+		}
+		buf.WriteString(tok)
+		line += len(tok)
+	}
+	buf.WriteString("\n\n")
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Error for a game in a PGN stream that couldn't be read. The reader skips
+// to the next game, so reading can continue after one.
+type PGNError struct {
+	Game int
+	Line int
+	Err  error
+}
+
+func (e *PGNError) Error() string {
+	return fmt.Sprintf("pgn game %d, line %d: %v", e.Game, e.Line, e.Err)
+}
+func (e *PGNError) Unwrap() error {
+	return e.Err
+}
+
+// Reads games one at a time from a PGN stream
+type PGNReader struct {
+	r     *bufio.Reader
+	line  int
+	games int
+}
+
+func NewPGNReader(r io.Reader) *PGNReader {
+	return &PGNReader{r: bufio.NewReader(r), line: 1}
+}
+
+var pgnResults = map[string]bool{"1-0": true, "0-1": true, "1/2-1/2": true, "*": true}
+
+// Next returns the next game in the stream, or io.EOF after the last one
+func (p *PGNReader) Next() (*Game, error) {
+	game := &Game{Tags: make(map[string]string)}
+	var board *Board
+	started := false
+
+	for {
+		tok, err := p.token()
+		if err == io.EOF && started {
+			return game, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !started {
+			started = true
+			p.games++
+		}
+
+		switch {
+		case tok == "[":
+			name, err := p.token()
+			if err != nil {
+				return nil, p.skip(fmt.Errorf("unterminated tag"))
+			}
+			value, err := p.token()
+			if err != nil || !strings.HasPrefix(value, `"`) {
+				return nil, p.skip(fmt.Errorf("tag %s without a string value", name))
+			}
+			if end, err := p.token(); err != nil || end != "]" {
+				return nil, p.skip(fmt.Errorf("tag %s not closed", name))
+			}
+			game.Tags[name] = value[1:]
+		case tok == "(":
+			if err := p.skipVariation(); err != nil {
+				return nil, err
+			}
+		case tok[0] == '{':
+			game.annotate(len(game.Moves)-1, func(a *Annotation) {
+				a.Comment = strings.TrimSpace(a.Comment + " " + strings.TrimSpace(tok[1:]))
+			})
+		case tok[0] == '$':
+			if nag, err := strconv.Atoi(tok[1:]); err == nil {
+				game.annotate(len(game.Moves)-1, func(a *Annotation) { a.NAG = nag })
+			}
+		case tok == ".", isMoveNumber(tok):
+		case pgnResults[tok]:
+			game.Result = tok
+			return game, nil
+		default:
+			if board == nil {
+				if board, err = game.StartingPosition(); err != nil {
+					return nil, p.skip(err)
+				}
+			}
+
+			san := strings.TrimRight(tok, "!?")
+			move, err := board.Play(san)
+			if err != nil {
+				return nil, p.skip(err)
+			}
+			game.Moves = append(game.Moves, move)
+
+			for nag, suffix := range nagSuffixes {
+				if suffix != "" && suffix == tok[len(san):] {
+					game.annotate(len(game.Moves)-1, func(a *Annotation) { a.NAG = nag })
+				}
+			}
+		}
+	}
+}
+
+// Reads the rest of the current game after an error
+func (p *PGNReader) skip(cause error) error {
+	err := &PGNError{p.games, p.line, cause}
+	for {
+		tok, e := p.token()
+		if e != nil || pgnResults[tok] {
+			return err
+		}
+	}
+}
+
+func (p *PGNReader) skipVariation() error {
+	for depth := 1; depth > 0; {
+		tok, err := p.token()
+		if err != nil {
+			return &PGNError{p.games, p.line, fmt.Errorf("unterminated variation")}
+		}
+
+		if tok == "(" {
+			depth++
+		} else if tok == ")" {
+			depth--
+		}
+	}
+	return nil
+}
+
+func isMoveNumber(tok string) bool {
+	for _, c := range tok {
+		if !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the next PGN token. Strings keep their opening quote and comments
+// their opening brace so they can't be confused with symbols.
+func (p *PGNReader) token() (string, error) {
+	c, err := p.skipSpace()
+	if err != nil {
+		return "", err
+	}
+
+	switch c {
+	case '[', ']', '(', ')', '.', '*':
+		return string(c), nil
+	case '"':
+		buf := bytes.Buffer{}
+		buf.WriteRune(c)
+		for escaped := false; ; {
+			c, err := p.read()
+			if err != nil {
+				return "", err
+			}
+			if c == '"' && !escaped {
+				return buf.String(), nil
+			}
+
+			if escaped = c == '\\' && !escaped; !escaped {
+				buf.WriteRune(c)
+			}
+		}
+	case '{':
+		buf := bytes.Buffer{}
+		buf.WriteRune(c)
+		for {
+			c, err := p.read()
+			if err != nil {
+				return "", err
+			}
+			if c == '}' {
+				return buf.String(), nil
+			}
+			buf.WriteRune(c)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteRune(c)
+	for {
+		c, err := p.read()
+		if err == io.EOF {
+			return buf.String(), nil
+		} else if err != nil {
+			return "", err
+		}
+
+		if unicode.IsSpace(c) || strings.ContainsRune(`[](){}".;`, c) {
+			p.unread(c)
+			return buf.String(), nil
+		}
+		buf.WriteRune(c)
+	}
+}
+
+// Skips whitespace, ; comments and % escaped lines
+func (p *PGNReader) skipSpace() (rune, error) {
+	startOfLine := false
+	for {
+		c, err := p.read()
+		if err != nil {
+			return 0, err
+		}
+
+		if c == ';' || (c == '%' && startOfLine) {
+			for c != '\n' {
+				if c, err = p.read(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if c == '\n' {
+			startOfLine = true
+		} else if !unicode.IsSpace(c) {
+			return c, nil
+		}
+	}
+}
+
+func (p *PGNReader) read() (rune, error) {
+	c, _, err := p.r.ReadRune()
+	if c == '\n' {
+		p.line++
+	}
+	return c, err
+}
+func (p *PGNReader) unread(c rune) {
+	if p.r.UnreadRune() == nil && c == '\n' {
+		p.line--
+	}
+}
@@ -0,0 +1,334 @@
+package chess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// PolyglotKey returns the Zobrist hash Polyglot opening books index
+// positions by
+func (board *Board) PolyglotKey() (key uint64) {
+	for i, piece := range board.squares {
+		if !piece.IsValid() {
+			continue
+		}
+
+		kind := (int(piece.Name)-1)*2 + 1 // black pawn 0, white pawn 1, black knight 2, ...
+		if piece.Color == Black {
+			kind--
+		}
+		key ^= polyglotRandom[64*kind+i]
+	}
+
+	for i, castle := range [...]struct {
+		color SideColor
+		side  CastleSide
+	}{{White, Kingside}, {White, Queenside}, {Black, Kingside}, {Black, Queenside}} {
+		if board.CastleRights.Can(castle.color, castle.side) {
+			key ^= polyglotRandom[768+i]
+		}
+	}
+
+	// the en passant file only counts if a pawn can actually capture there
+	if ep := board.EnPassantTarget; ep.IsValid() {
+		rank := ep.Rank - 1
+		if board.SideToMove == Black {
+			rank = ep.Rank + 1
+		}
+
+		for _, f := range [...]int{ep.File - 1, ep.File + 1} {
+			if piece := board.At(Coord{f, rank}); piece != nil && *piece == (Piece{board.SideToMove, Pawn}) {
+				key ^= polyglotRandom[772+ep.File-1]
+				break
+			}
+		}
+	}
+
+	if board.SideToMove == White {
+		key ^= polyglotRandom[780]
+	}
+	return
+}
+
+type BookEntry struct {
+	Move   Move
+	Weight int
+	Learn  uint32
+}
+
+type polyglotEntry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+// An opening book in the Polyglot .bin format
+type Book struct {
+	entries []polyglotEntry
+}
+
+func OpenBook(path string) (*Book, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewBook(bufio.NewReader(f))
+}
+
+// Reads a Polyglot book, a sequence of 16 byte big-endian entries
+func NewBook(r io.Reader) (*Book, error) {
+	book := &Book{}
+
+	for {
+		var entry polyglotEntry
+		if err := binary.Read(r, binary.BigEndian, &entry); err == io.EOF {
+			break
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated book entry %d", len(book.entries))
+		} else if err != nil {
+			return nil, err
+		}
+
+		book.entries = append(book.entries, entry)
+	}
+
+	// books should already be sorted by key, but lookups rely on it
+	sort.SliceStable(book.entries, func(i, j int) bool {
+		return book.entries[i].Key < book.entries[j].Key
+	})
+	return book, nil
+}
+
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Lookup returns the legal book moves for the position, highest weight first
+func (b *Book) Lookup(board *Board) []BookEntry {
+	key := board.PolyglotKey()
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return b.entries[i].Key >= key
+	})
+
+	legal := board.Moves()
+	found := make([]BookEntry, 0, 4)
+	for ; i < len(b.entries) && b.entries[i].Key == key; i++ {
+		move := decodePolyglotMove(b.entries[i].Move, board)
+
+		for _, m := range legal {
+			if m.Matches(move) && m.PromotesTo == move.PromotesTo {
+				found = append(found, BookEntry{m, int(b.entries[i].Weight), b.entries[i].Learn})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Weight > found[j].Weight
+	})
+	return found
+}
+
+// Pick chooses one of the book moves for the position at random, in
+// proportion to their weights. A nil r uses the default source.
+func (b *Book) Pick(board *Board, r *rand.Rand) (Move, bool) {
+	entries := b.Lookup(board)
+
+	total := 0
+	for _, e := range entries {
+		total += e.Weight
+	}
+	if total == 0 {
+		return Move{}, false
+	}
+
+	var n int
+	if r == nil {
+		n = rand.Intn(total)
+	} else {
+		n = r.Intn(total)
+	}
+
+	for _, e := range entries {
+		if n -= e.Weight; n < 0 {
+			return e.Move, true
+		}
+	}
+	panic("unreachable")
+}
+
+var polyglotPromotions = [...]PieceName{0, Knight, Bishop, Rook, Queen}
+
+// Polyglot moves pack the squares as 3 bit files and ranks, from the low bits
+// up: to file, to rank, from file, from rank, promotion piece. Castling is
+// written as the king capturing its own rook.
+func decodePolyglotMove(m uint16, board *Board) (move Move) {
+	move.To = Coord{int(m&7) + 1, int(m>>3&7) + 1}
+	move.From = Coord{int(m>>6&7) + 1, int(m>>9&7) + 1}
+	if promotion := int(m >> 12 & 7); promotion < len(polyglotPromotions) {
+		move.PromotesTo = polyglotPromotions[promotion]
+	}
+
+	if piece := board.At(move.From); piece.Name == King && move.From.File == 5 && move.From.Rank == move.To.Rank {
+		if move.To.File == 8 {
+			move.To.File = 7
+		} else if move.To.File == 1 {
+			move.To.File = 3
+		}
+	}
+	return
+}
+
+func encodePolyglotMove(move Move) uint16 {
+	to := move.To
+	if move.CastlesTo == Kingside {
+		to.File = 8
+	} else if move.CastlesTo == Queenside {
+		to.File = 1
+	}
+
+	m := uint16(to.File-1) | uint16(to.Rank-1)<<3 | uint16(move.From.File-1)<<6 | uint16(move.From.Rank-1)<<9
+	for i, name := range polyglotPromotions {
+		if name != 0 && name == move.PromotesTo {
+			m |= uint16(i) << 12
+		}
+	}
+	return m
+}
+
+// Writes the book in the Polyglot .bin format
+func (b *Book) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	for _, entry := range b.entries {
+		if err := binary.Write(bw, binary.BigEndian, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(b.entries) * 16), bw.Flush()
+}
+
+// Results of the games a move was played in, from the mover's point of view
+type BookMoveStats struct {
+	Games, Wins, Draws, Losses int
+}
+
+// Collects the moves played in games into an opening book
+type BookBuilder struct {
+	MaxPly   int // moves after this many plies are ignored, 0 for no limit
+	MinGames int // moves played in fewer games are left out of the book
+
+	positions map[uint64]map[uint16]*BookMoveStats
+}
+
+func NewBookBuilder(maxPly int) *BookBuilder {
+	return &BookBuilder{
+		MaxPly:    maxPly,
+		MinGames:  1,
+		positions: make(map[uint64]map[uint16]*BookMoveStats),
+	}
+}
+
+func (bb *BookBuilder) AddGame(game *Game) error {
+	board, err := game.StartingPosition()
+	if err != nil {
+		return err
+	}
+
+	for ply, move := range game.Moves {
+		if bb.MaxPly > 0 && ply >= bb.MaxPly {
+			break
+		}
+
+		key := board.PolyglotKey()
+		if bb.positions[key] == nil {
+			bb.positions[key] = make(map[uint16]*BookMoveStats)
+		}
+		m := encodePolyglotMove(move)
+		stats := bb.positions[key][m]
+		if stats == nil {
+			stats = new(BookMoveStats)
+			bb.positions[key][m] = stats
+		}
+
+		stats.Games++
+		switch {
+		case game.Result == "1/2-1/2":
+			stats.Draws++
+		case (game.Result == "1-0" && board.SideToMove == White) || (game.Result == "0-1" && board.SideToMove == Black):
+			stats.Wins++
+		case game.Result == "1-0" || game.Result == "0-1":
+			stats.Losses++
+		}
+
+		if err := board.PlayMove(move); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the results of the moves collected for the position, keyed
+// by the moves in UCI notation
+func (bb *BookBuilder) Stats(board *Board) map[string]BookMoveStats {
+	stats := make(map[string]BookMoveStats)
+	for m, s := range bb.positions[board.PolyglotKey()] {
+		stats[decodePolyglotMove(m, board).UCI()] = *s
+	}
+	return stats
+}
+
+// Book returns the collected moves as an opening book. Moves are weighted by
+// two points per win and one per draw, like Polyglot's make-book, and scaled
+// down per position where needed to fit the 16 bit weights. Moves that never
+// scored are left out.
+func (bb *BookBuilder) Book() *Book {
+	book := &Book{entries: make([]polyglotEntry, 0, len(bb.positions))}
+
+	for key, moves := range bb.positions {
+		max := 0
+		for _, stats := range moves {
+			if w := stats.weight(); w > max {
+				max = w
+			}
+		}
+
+		for m, stats := range moves {
+			w := stats.weight()
+			if stats.Games < bb.MinGames || w == 0 {
+				continue
+			}
+			if max > 0xFFFF {
+				if w = w * 0xFFFF / max; w == 0 {
+					w = 1
+				}
+			}
+			book.entries = append(book.entries, polyglotEntry{Key: key, Move: m, Weight: uint16(w)})
+		}
+	}
+
+	sort.Slice(book.entries, func(i, j int) bool {
+		a, b := book.entries[i], book.entries[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		return a.Move < b.Move
+	})
+	return book
+}
+
+func (s *BookMoveStats) weight() int {
+	return 2*s.Wins + s.Draws
+}
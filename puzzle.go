@@ -0,0 +1,184 @@
+package chess
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A position with a single winning line for the side to move
+type Puzzle struct {
+	Board    *Board
+	Blunder  Move   // the move that allowed the puzzle, if it came from a game
+	Ply      int    // the index of Blunder in the game's moves
+	Solution []Move // the solver's moves and the replies between them
+	Score    Score  // for the solver, before the first move
+	Tags     []string
+}
+
+// UCI returns the solution in UCI notation, separated by spaces
+func (p Puzzle) UCI() string {
+	moves := make([]string, len(p.Solution))
+	for i, m := range p.Solution {
+		moves[i] = m.UCI()
+	}
+	return strings.Join(moves, " ")
+}
+
+// String returns the puzzle as the FEN, the solution and the tags separated
+// by commas, e.g. "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1,a1a8,backRankWeakness mate mateIn1"
+func (p Puzzle) String() string {
+	return p.Board.String() + "," + p.UCI() + "," + strings.Join(p.Tags, " ")
+}
+
+// Finds puzzles in games: positions after a move that threw away a winning
+// advantage, or let the opponent win one, where exactly one move keeps it at
+// every step of the solution
+type PuzzleFinder struct {
+	Limits   SearchLimits // the search of every position
+	Winning  int          // the centipawns an advantage needs to be winning
+	Swing    int          // the centipawns the blunder has to lose
+	MaxMoves int          // solver moves in a solution at most
+
+	Searcher Searcher
+}
+
+func NewPuzzleFinder(limits SearchLimits) *PuzzleFinder {
+	return &PuzzleFinder{Limits: limits, Winning: 300, Swing: 300, MaxMoves: 3}
+}
+
+// Find returns the puzzles of a game, in the order they came up
+func (pf *PuzzleFinder) Find(game *Game) ([]Puzzle, error) {
+	board, err := game.StartingPosition()
+	if err != nil {
+		return nil, err
+	}
+
+	var puzzles []Puzzle
+	before := pf.search(board, 1)
+	for ply, move := range game.Moves {
+		if err := board.PlayMove(move); err != nil {
+			return puzzles, err
+		}
+		if board.GameOver() {
+			break
+		}
+
+		// scores are for the side to move, so the one after the move is
+		// negated for the player who made it
+		after := pf.search(board, 1)
+		if len(before) > 0 && len(after) > 0 && int(before[0].Score+after[0].Score) >= pf.Swing && int(after[0].Score) >= pf.Winning {
+			if puzzle, ok := pf.solve(board); ok {
+				puzzle.Blunder, puzzle.Ply = move, ply
+				puzzles = append(puzzles, puzzle)
+			}
+		}
+		before = after
+	}
+	return puzzles, nil
+}
+
+// Solve returns the puzzle of a position if the side to move has a single
+// winning line
+func (pf *PuzzleFinder) Solve(board *Board) (Puzzle, bool) {
+	return pf.solve(board)
+}
+
+func (pf *PuzzleFinder) search(board *Board, lines int) []SearchLine {
+	limits := pf.Limits
+	limits.MultiPV = lines
+	return pf.Searcher.Search(board, limits).Lines
+}
+
+// Plays out the solution on a copy of the board
+func (pf *PuzzleFinder) solve(board *Board) (Puzzle, bool) {
+	puzzle := Puzzle{Board: board.Clone()}
+	replay := board.Clone()
+	start := Evaluate(replay)
+	tags := make(map[string]bool)
+	for _, m := range replay.Motifs() {
+		if m.Side == replay.SideToMove {
+			tags[motifTag(m.Type)] = true
+		}
+	}
+
+	for n := 0; n < pf.MaxMoves; n++ {
+		lines := pf.search(replay, 2)
+		if len(lines) == 0 || int(lines[0].Score) < pf.Winning {
+			break
+		}
+		if len(lines) > 1 && int(lines[1].Score) >= pf.Winning && !pf.uniqueMate(replay, lines[0].Score) {
+			break
+		}
+		if n == 0 {
+			puzzle.Score = lines[0].Score
+		}
+
+		move := lines[0].PV[0]
+		for _, m := range replay.MoveMotifs(move) {
+			tags[motifTag(m.Type)] = true
+		}
+		replay.MakeMove(move)
+		puzzle.Solution = append(puzzle.Solution, move)
+
+		if replay.InCheckmate() {
+			moves := (len(puzzle.Solution) + 1) / 2
+			tags["mate"], tags[fmt.Sprintf("mateIn%d", moves)] = true, true
+			break
+		}
+		if replay.GameOver() {
+			break
+		}
+
+		reply := Move{}
+		if len(lines[0].PV) > 1 {
+			reply = lines[0].PV[1]
+		} else if r := pf.search(replay, 1); len(r) > 0 {
+			reply = r[0].PV[0]
+		}
+		if !reply.IsValid() {
+			break
+		}
+		replay.MakeMove(reply)
+
+		// done once the advantage is won in material
+		if Evaluate(replay)-start >= pf.Winning {
+			break
+		}
+		puzzle.Solution = append(puzzle.Solution, reply)
+	}
+
+	if len(puzzle.Solution)%2 == 0 {
+		// the line has to end on the solver's move
+		if len(puzzle.Solution) == 0 {
+			return puzzle, false
+		}
+		puzzle.Solution = puzzle.Solution[:len(puzzle.Solution)-1]
+	}
+
+	for tag := range tags {
+		puzzle.Tags = append(puzzle.Tags, tag)
+	}
+	sort.Strings(puzzle.Tags)
+	return puzzle, true
+}
+
+// Whether a short mate the search found is the only one of its length, as
+// with longer mates a second winning move doesn't make for another solution
+func (pf *PuzzleFinder) uniqueMate(board *Board, score Score) bool {
+	moves, ok := score.Mate()
+	if !ok || moves <= 0 || moves > 3 {
+		return false
+	}
+	solution, found := FindMate(board, moves)
+	return found && solution.Unique()
+}
+
+// Turns a motif's name into a single word tag, e.g. discoveredAttack
+func motifTag(t MotifType) string {
+	words := strings.Fields(t.String())
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+	return strings.Join(words, "")
+}
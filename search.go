@@ -0,0 +1,528 @@
+package chess
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scores within maxPly of MateScore are mates, MateScore-n being mate in n
+// plies for the side to move and n-MateScore being mated in n plies
+const MateScore = 100000
+
+const (
+	maxPly   = 64
+	infinity = MateScore + 1
+
+	mateBound = MateScore - 1000 // scores beyond this are mates
+)
+
+// A score in centipawns from the side to move's point of view, or a forced
+// mate as MateScore describes
+type Score int
+
+// MateIn returns the score of mate in the given number of moves for the side
+// to move, or of being mated in -moves moves if it's 0 or less
+func MateIn(moves int) Score {
+	if moves > 0 {
+		return Score(MateScore - 2*moves + 1)
+	}
+	return Score(-MateScore - 2*moves)
+}
+
+func (s Score) IsMate() bool {
+	return s > mateBound || s < -mateBound
+}
+
+// Mate returns the number of moves to the mate the score stands for, negative
+// if the side to move is the one mated, and whether it's a mate at all
+func (s Score) Mate() (int, bool) {
+	switch {
+	case s > mateBound:
+		return (MateScore - int(s) + 1) / 2, true
+	case s < -mateBound:
+		return -(MateScore + int(s) + 1) / 2, true
+	}
+	return 0, false
+}
+
+// String returns the score in pawns, e.g. +0.35, or as a mate, e.g. #3 or #-2
+func (s Score) String() string {
+	if moves, ok := s.Mate(); ok {
+		return fmt.Sprintf("#%d", moves)
+	}
+	return fmt.Sprintf("%+.2f", float64(s)/100)
+}
+
+// UCI returns the score as UCI info lines give it, e.g. "cp 35" or "mate -2"
+func (s Score) UCI() string {
+	if moves, ok := s.Mate(); ok {
+		return fmt.Sprintf("mate %d", moves)
+	}
+	return fmt.Sprintf("cp %d", s)
+}
+
+// Limits on a search. A search without any stops after maxPly iterations.
+type SearchLimits struct {
+	Depth    int           // plies, 0 for no limit
+	MoveTime time.Duration // 0 for no limit
+	Nodes    int           // 0 for no limit
+	MultiPV  int           // number of lines to search, 0 for one
+
+	Infinite bool // search until stopped, whatever the other limits
+	Ponder   bool // search until PonderHit as if infinite, then within the limits
+}
+
+// The best line of a search, along with the next best ones in Lines when
+// more than one was asked for
+type SearchResult struct {
+	Move   Move
+	Ponder Move // the reply expected to Move, if there is one
+	Score  Score
+	Depth  int // the deepest iteration that completed
+	Nodes  int
+	PV     []Move
+	Time   time.Duration
+
+	Lines []SearchLine // best first, the first being the line above
+}
+
+type SearchLine struct {
+	Score Score
+	PV    []Move
+}
+
+// Progress of a search in the form UCI engines report it in info lines. Only
+// the fields the line had are set.
+type SearchInfo struct {
+	Depth      int
+	SelDepth   int
+	MultiPV    int
+	Score      Score
+	LowerBound bool
+	UpperBound bool
+	Nodes      int
+	NPS        int
+	Time       time.Duration
+	PV         []Move
+	String     string
+}
+
+// An alpha-beta searcher. The zero value searches with Evaluate; a Searcher
+// runs one search at a time, which Stop and PonderHit may act on from other
+// goroutines.
+type Searcher struct {
+	Eval func(*Board) int // static evaluation from the side to move's point of view
+
+	// Now tells the time for MoveTime and reports, time.Now if it's nil, so
+	// tests can control it
+	Now func() time.Time
+
+	// Info is called with each line as an iteration completes, and with the
+	// node count about once a second in between. It runs on the searching
+	// goroutine, so it shouldn't block.
+	Info func(SearchInfo)
+
+	board    *Board
+	limits   SearchLimits
+	start    time.Time
+	lastInfo time.Time
+	depth    int
+	selDepth int
+	nodes    int
+	stopped  int32
+	canStop  bool // the first iteration always completes so there is a move
+
+	mu        sync.Mutex // guards the fields Prepare and PonderHit use
+	prepared  bool
+	pondering bool
+	deadline  time.Time
+	wake      chan struct{}
+
+	pv       [maxPly + 1][maxPly + 1]Move
+	pvLen    [maxPly + 1]int
+	lastPV   []Move
+	killers  [maxPly + 1][2]Move
+	excluded []Move // root moves already heading a line of a multi-PV search
+}
+
+// Search runs an iterative deepening search on a copy of the board. Infinite
+// and pondering searches don't return before Stop, or PonderHit and the end
+// of the search it starts, even if they run out of depth.
+func (s *Searcher) Search(board *Board, limits SearchLimits) SearchResult {
+	s.start = s.now()
+	s.lastInfo = s.start
+	s.board = board.Clone()
+	s.nodes, s.selDepth = 0, 0
+	s.canStop = false
+	s.lastPV = nil
+	s.killers = [maxPly + 1][2]Move{}
+
+	s.mu.Lock()
+	if !s.prepared {
+		s.prepare(limits, s.start)
+	}
+	s.prepared = false
+	s.mu.Unlock()
+
+	depth := limits.Depth
+	if depth <= 0 || depth > maxPly || limits.Infinite {
+		depth = maxPly
+	}
+
+	result := SearchResult{}
+	var lines []SearchLine
+	for s.depth = 1; s.depth <= depth; s.depth++ {
+		found := make([]SearchLine, 0, limits.MultiPV)
+		s.excluded = s.excluded[:0]
+		for n := 0; n == 0 || n < limits.MultiPV; n++ {
+			s.lastPV = nil
+			if n < len(lines) {
+				s.lastPV = lines[n].PV
+			}
+
+			score := s.negamax(s.depth, 0, -infinity, infinity, true)
+			if s.stop() || s.pvLen[0] == 0 {
+				break
+			}
+			pv := append([]Move(nil), s.pv[0][:s.pvLen[0]]...)
+			found = append(found, SearchLine{Score(score), pv})
+			s.excluded = append(s.excluded, pv[0])
+		}
+		if s.stop() {
+			break
+		}
+		s.canStop = true
+
+		lines = found
+		result.Depth, result.Lines = s.depth, lines
+		if len(lines) == 0 {
+			break // no legal moves
+		}
+		s.report(lines)
+
+		result.Score, result.PV = lines[0].Score, lines[0].PV
+		result.Move, result.Ponder = lines[0].PV[0], Move{}
+		if len(lines[0].PV) > 1 {
+			result.Ponder = lines[0].PV[1]
+		}
+
+		// a proven mate won't change with more depth
+		if score := int(lines[0].Score); len(lines) == 1 && (score >= MateScore-s.depth || score <= s.depth-MateScore) {
+			break
+		}
+	}
+
+	for s.waiting() {
+		<-s.wake
+	}
+
+	result.Nodes = s.nodes
+	result.Time = s.now().Sub(s.start)
+	return result
+}
+
+// Prepare resets the stop and ponder state for a search with the given
+// limits. A caller that runs Search on another goroutine calls it first, so a
+// Stop or PonderHit sent before that search gets going isn't lost; Search
+// prepares itself otherwise.
+func (s *Searcher) Prepare(limits SearchLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepare(limits, s.now())
+	s.prepared = true
+}
+
+// prepare is Prepare with s.mu held, for a search starting at now
+func (s *Searcher) prepare(limits SearchLimits, now time.Time) {
+	atomic.StoreInt32(&s.stopped, 0)
+	s.limits = limits
+	s.pondering = limits.Ponder
+	s.deadline = time.Time{}
+	if limits.MoveTime > 0 && !limits.Ponder {
+		s.deadline = now.Add(limits.MoveTime)
+	}
+	s.wake = make(chan struct{}, 1)
+}
+
+// Whether the search has to wait to be stopped before returning
+func (s *Searcher) waiting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return (s.limits.Infinite || s.pondering) && atomic.LoadInt32(&s.stopped) == 0
+}
+
+func (s *Searcher) report(lines []SearchLine) {
+	if s.Info == nil {
+		return
+	}
+
+	now := s.now()
+	elapsed := now.Sub(s.start)
+	for n, line := range lines {
+		s.Info(SearchInfo{
+			Depth:    s.depth,
+			SelDepth: s.selDepth,
+			MultiPV:  n + 1,
+			Score:    line.Score,
+			Nodes:    s.nodes,
+			NPS:      nps(s.nodes, elapsed),
+			Time:     elapsed,
+			PV:       line.PV,
+		})
+	}
+	s.lastInfo = now
+}
+
+func nps(nodes int, elapsed time.Duration) int {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int(float64(nodes) / elapsed.Seconds())
+}
+
+// Stop ends the running search, which returns the result of the last
+// iteration that completed
+func (s *Searcher) Stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.signal()
+}
+
+// PonderHit turns a pondering search into one within its limits, with the
+// time limit counting from now. It's for when the opponent plays the move
+// the search was pondering on.
+func (s *Searcher) PonderHit() {
+	s.mu.Lock()
+	s.pondering = false
+	if s.limits.MoveTime > 0 {
+		s.deadline = s.now().Add(s.limits.MoveTime)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *Searcher) signal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake != nil {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Searcher) stop() bool {
+	// even Stop waits for the first iteration, so there's a move to play
+	if !s.canStop {
+		return false
+	}
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		return true
+	}
+	if s.nodes&1023 != 0 {
+		return false
+	}
+
+	now := s.now()
+	if s.Info != nil && now.Sub(s.lastInfo) >= time.Second {
+		elapsed := now.Sub(s.start)
+		s.Info(SearchInfo{Depth: s.depth, SelDepth: s.selDepth, Nodes: s.nodes, NPS: nps(s.nodes, elapsed), Time: elapsed})
+		s.lastInfo = now
+	}
+
+	s.mu.Lock()
+	pondering, deadline := s.pondering, s.deadline
+	s.mu.Unlock()
+	if pondering || s.limits.Infinite {
+		return false
+	}
+
+	if (!deadline.IsZero() && now.After(deadline)) || (s.limits.Nodes > 0 && s.nodes >= s.limits.Nodes) {
+		atomic.StoreInt32(&s.stopped, 1)
+		return true
+	}
+	return false
+}
+
+func (s *Searcher) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Searcher) eval() int {
+	if s.Eval != nil {
+		return s.Eval(s.board)
+	}
+	return Evaluate(s.board)
+}
+
+func (s *Searcher) negamax(depth, ply, alpha, beta int, onPV bool) int {
+	s.pvLen[ply] = 0
+	if ply > 0 && s.stop() {
+		return 0
+	}
+
+	inCheck := s.board.InCheck(s.board.SideToMove)
+	// the fifty move rule draws, unless the move that reached it mates
+	if ply > 0 && s.board.HalfmoveClock >= 100 && (!inCheck || len(s.board.Moves()) > 0) {
+		return 0
+	}
+	if inCheck {
+		depth++ // don't stop searching with the king in check
+	}
+	if depth <= 0 || ply >= maxPly {
+		return s.quiesce(ply, alpha, beta)
+	}
+	s.nodes++
+
+	moves := s.board.Moves()
+	if len(moves) == 0 {
+		if inCheck {
+			return ply - MateScore
+		}
+		return 0
+	}
+	if ply == 0 && len(s.excluded) > 0 {
+		moves = s.excludeRootMoves(moves)
+	}
+
+	var pvMove Move
+	if onPV && ply < len(s.lastPV) {
+		pvMove = s.lastPV[ply]
+	}
+	s.order(moves, ply, pvMove)
+
+	best := -infinity
+	for i, move := range moves {
+		s.board.MakeMove(move)
+		score := -s.negamax(depth-1, ply+1, -beta, -alpha, onPV && i == 0 && sameMove(move, pvMove))
+		s.board.UnmakeMove()
+		if ply > 0 && s.stop() {
+			return 0
+		}
+
+		if score > best {
+			best = score
+		}
+		if score > alpha {
+			alpha = score
+			s.pv[ply][0] = move
+			copy(s.pv[ply][1:], s.pv[ply+1][:s.pvLen[ply+1]])
+			s.pvLen[ply] = s.pvLen[ply+1] + 1
+		}
+		if alpha >= beta {
+			if !move.Captures.IsValid() && !sameMove(move, s.killers[ply][0]) {
+				s.killers[ply][1] = s.killers[ply][0]
+				s.killers[ply][0] = move
+			}
+			break
+		}
+	}
+	return best
+}
+
+func (s *Searcher) excludeRootMoves(moves []Move) []Move {
+	kept := moves[:0]
+	for _, move := range moves {
+		excluded := false
+		for _, e := range s.excluded {
+			excluded = excluded || sameMove(move, e)
+		}
+		if !excluded {
+			kept = append(kept, move)
+		}
+	}
+	return kept
+}
+
+// Searches captures until the position is quiet so the evaluation isn't
+// taken in the middle of an exchange
+func (s *Searcher) quiesce(ply, alpha, beta int) int {
+	s.nodes++
+	s.pvLen[ply] = 0
+	if ply > s.selDepth {
+		s.selDepth = ply
+	}
+
+	best := s.eval()
+	if ply >= maxPly || best >= beta {
+		return best
+	}
+	if best > alpha {
+		alpha = best
+	}
+
+	moves := s.board.Moves()
+	captures := moves[:0]
+	for _, move := range moves {
+		if move.Captures.IsValid() || move.IsEnPassant || move.PromotesTo == Queen {
+			captures = append(captures, move)
+		}
+	}
+	s.order(captures, ply, Move{})
+
+	for _, move := range captures {
+		s.board.MakeMove(move)
+		score := -s.quiesce(ply+1, -beta, -alpha)
+		s.board.UnmakeMove()
+		if s.stop() {
+			return 0
+		}
+
+		if score > best {
+			best = score
+		}
+		if score > alpha {
+			alpha = score
+			s.pv[ply][0] = move
+			copy(s.pv[ply][1:], s.pv[ply+1][:s.pvLen[ply+1]])
+			s.pvLen[ply] = s.pvLen[ply+1] + 1
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
+
+// Orders the moves to try the previous iteration's best move first, then
+// captures of the most valuable pieces by the least valuable ones, then
+// moves that caused cutoffs at the same ply
+func (s *Searcher) order(moves []Move, ply int, pvMove Move) {
+	scores := make([]int, len(moves))
+	for i, move := range moves {
+		switch {
+		case sameMove(move, pvMove):
+			scores[i] = 1 << 20
+		case move.Captures.IsValid() || move.PromotesTo.IsValid():
+			scores[i] = 1<<16 + 10*(pieceValues[move.Captures]+pieceValues[move.PromotesTo]) - pieceValues[move.Moves]
+		case sameMove(move, s.killers[ply][0]):
+			scores[i] = 1<<15 + 1
+		case sameMove(move, s.killers[ply][1]):
+			scores[i] = 1 << 15
+		}
+	}
+
+	sort.Stable(orderedMoves{moves, scores})
+}
+
+type orderedMoves struct {
+	moves  []Move
+	scores []int
+}
+
+func (o orderedMoves) Len() int           { return len(o.moves) }
+func (o orderedMoves) Less(i, j int) bool { return o.scores[i] > o.scores[j] }
+func (o orderedMoves) Swap(i, j int) {
+	o.moves[i], o.moves[j] = o.moves[j], o.moves[i]
+	o.scores[i], o.scores[j] = o.scores[j], o.scores[i]
+}
+
+func sameMove(a, b Move) bool {
+	return a.Matches(b) && a.PromotesTo == b.PromotesTo
+}
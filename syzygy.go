@@ -0,0 +1,1290 @@
+package chess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A tablebase verdict for the side to move. Cursed wins and blessed losses
+// are wins and losses that take too long, so the fifty move rule draws them.
+type WDL int
+
+const (
+	WDLLoss WDL = iota - 2
+	WDLBlessedLoss
+	WDLDraw
+	WDLCursedWin
+	WDLWin
+)
+
+func (w WDL) String() string {
+	switch w {
+	case WDLLoss:
+		return "loss"
+	case WDLBlessedLoss:
+		return "blessed loss"
+	case WDLDraw:
+		return "draw"
+	case WDLCursedWin:
+		return "cursed win"
+	case WDLWin:
+		return "win"
+	default:
+		return ""
+	}
+}
+
+// Syzygy endgame tablebases, the .rtbw and .rtbz files of a directory. The
+// first give the verdict for each position of an ending, the second its
+// distance to zeroing (DTZ): the plies until a capture or pawn move that keeps
+// the verdict, with best play. Tables are read from disk as positions need
+// them, and probing is safe from several goroutines.
+type Tablebase struct {
+	MaxPieces int // in the largest ending there's a table for, kings included
+
+	tables map[string]*tbTable // by file name
+}
+
+// OpenTablebase finds the tables in the directory, without reading them yet
+func OpenTablebase(dir string) (*Tablebase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tb := &Tablebase{tables: make(map[string]*tbTable)}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if ext != ".rtbw" && ext != ".rtbz" {
+			continue
+		}
+		t, ok := newTBTable(strings.TrimSuffix(entry.Name(), ext), ext == ".rtbz")
+		if !ok {
+			continue
+		}
+
+		t.path = filepath.Join(dir, entry.Name())
+		tb.tables[entry.Name()] = t
+		if !t.dtz && t.pieceCount > tb.MaxPieces {
+			tb.MaxPieces = t.pieceCount
+		}
+	}
+	return tb, nil
+}
+
+// Close closes the tables read so far, once nothing probes them anymore
+func (tb *Tablebase) Close() error {
+	var err error
+	for _, t := range tb.tables {
+		if t.f != nil {
+			if e := t.f.Close(); err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// ProbeWDL returns the verdict for the side to move. Positions with castling
+// rights, more pieces than MaxPieces or no table for their ending give
+// ErrNotInTablebase.
+func (tb *Tablebase) ProbeWDL(board *Board) (WDL, error) {
+	if err := tb.covers(board); err != nil {
+		return 0, err
+	}
+	wdl, _, err := tb.search(board.Clone(), false)
+	return wdl, err
+}
+
+// ProbeDTZ returns the distance to zeroing in plies, positive if the side to
+// move wins and negative if it loses, 0 for a draw. The distance of cursed
+// wins and blessed losses counts an extra 100 plies. It's exact when the
+// table keeps plies, otherwise it can be one more than the true distance.
+func (tb *Tablebase) ProbeDTZ(board *Board) (int, error) {
+	if err := tb.covers(board); err != nil {
+		return 0, err
+	}
+	return tb.dtz(board.Clone())
+}
+
+// RootMoves returns the legal moves that are best by the tablebases: every
+// move that wins within the fifty move rule, or failing that the moves that
+// keep the best verdict and zero the soonest, or resist the longest when
+// losing. Like Stockfish, the halfmove clock and repetitions since the last
+// zeroing move count.
+func (tb *Tablebase) RootMoves(board *Board) ([]Move, error) {
+	if err := tb.covers(board); err != nil {
+		return nil, err
+	}
+
+	const maxDTZ = 1 << 18
+	b := board.Clone()
+	cnt50 := b.HalfmoveClock
+	repeated := false
+	keys := map[uint64]bool{}
+	for _, key := range tbPositionKeys(b) {
+		repeated = repeated || keys[key]
+		keys[key] = true
+	}
+
+	var best []Move
+	bestRank := -maxDTZ - 1
+	for _, move := range b.Moves() {
+		b.MakeMove(move)
+
+		var dtz int
+		var err error
+		if tbZeroing(move) {
+			var wdl WDL
+			wdl, _, err = tb.search(b, false)
+			dtz = tbDTZBeforeZeroing(-wdl)
+		} else if (b.HalfmoveClock >= 100 && !tbMated(b)) || tbRepetitions(b) >= 3 {
+			dtz = 0
+		} else {
+			dtz, err = tb.dtz(b)
+			switch dtz = -dtz; {
+			case dtz > 0:
+				dtz++
+			case dtz < 0:
+				dtz--
+			}
+		}
+
+		// a mate is one ply from zeroing, however the table counts it
+		if dtz == 2 && tbMated(b) {
+			dtz = 1
+		}
+		b.UnmakeMove()
+		if err != nil {
+			return nil, err
+		}
+
+		// wins within the fifty move rule rank the same, the rest by how
+		// close they come to it
+		rank := 0
+		switch {
+		case dtz > 0 && dtz+cnt50 <= 99 && !repeated:
+			rank = maxDTZ
+		case dtz > 0:
+			rank = maxDTZ/2 - (dtz + cnt50)
+		case dtz < 0 && -dtz*2+cnt50 < 100:
+			rank = -maxDTZ
+		case dtz < 0:
+			rank = -maxDTZ/2 + (-dtz + cnt50)
+		}
+
+		if rank > bestRank {
+			best, bestRank = best[:0], rank
+		}
+		if rank == bestRank {
+			best = append(best, move)
+		}
+	}
+	return best, nil
+}
+
+// Checks that the tablebases can have the position
+func (tb *Tablebase) covers(board *Board) error {
+	if board.CastleRights != 0 {
+		return fmt.Errorf("%w: castling rights %v", ErrNotInTablebase, board.CastleRights.String())
+	}
+	if n := tbCountPieces(board); n > tb.MaxPieces {
+		return fmt.Errorf("%w: %d pieces, tables have at most %d", ErrNotInTablebase, n, tb.MaxPieces)
+	}
+	return nil
+}
+
+// Returns the verdict, searching captures, and pawn moves too if zeroing is
+// set, as the tables can't tell about en passant and don't care about the
+// values of positions whose best move zeroes. Also reports whether the best
+// move is a zeroing one.
+func (tb *Tablebase) search(board *Board, zeroing bool) (WDL, bool, error) {
+	best := WDLLoss
+	moves := board.Moves()
+	searched := 0
+	for _, move := range moves {
+		if !tbCapture(move) && (!zeroing || move.Moves != Pawn) {
+			continue
+		}
+		searched++
+
+		board.MakeMove(move)
+		wdl, _, err := tb.search(board, false)
+		board.UnmakeMove()
+		if err != nil {
+			return 0, false, err
+		}
+
+		if -wdl > best {
+			if best = -wdl; best >= WDLWin {
+				return best, true, nil
+			}
+		}
+	}
+
+	// the table isn't needed when every move was searched
+	allSearched := searched > 0 && searched == len(moves)
+	value := best
+	if !allSearched {
+		v, _, err := tb.probeTable(board, false, 0)
+		if err != nil {
+			return 0, false, err
+		}
+		value = WDL(v)
+	}
+
+	if best >= value {
+		return best, best > WDLDraw || allSearched, nil
+	}
+	return value, false, nil
+}
+
+func (tb *Tablebase) dtz(board *Board) (int, error) {
+	wdl, zeroing, err := tb.search(board, true)
+	if err != nil || wdl == WDLDraw {
+		return 0, err
+	}
+	if zeroing {
+		return tbDTZBeforeZeroing(wdl), nil
+	}
+
+	dtz, otherSide, err := tb.probeTable(board, true, wdl)
+	if err != nil {
+		return 0, err
+	}
+	if !otherSide {
+		if wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+			dtz += 100
+		}
+		if wdl < 0 {
+			dtz = -dtz
+		}
+		return dtz, nil
+	}
+
+	// the table only has the other side to move, so look a ply ahead for the
+	// move that zeroes the soonest, or the latest when losing
+	best := 0xffff
+	for _, move := range board.Moves() {
+		zeroing := tbZeroing(move)
+		board.MakeMove(move)
+
+		var dtz int
+		if zeroing {
+			var wdl WDL
+			wdl, _, err = tb.search(board, false)
+			dtz = -tbDTZBeforeZeroing(wdl)
+		} else {
+			dtz, err = tb.dtz(board)
+			dtz = -dtz
+		}
+
+		if dtz == 1 && tbMated(board) {
+			best = 1 // mates
+		}
+		if !zeroing {
+			dtz += tbSign(dtz)
+		}
+		if dtz < best && tbSign(dtz) == tbSign(int(wdl)) {
+			best = dtz
+		}
+
+		board.UnmakeMove()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// no legal moves, so mated
+	if best == 0xffff {
+		return -1, nil
+	}
+	return best, nil
+}
+
+// Looks the position up in its table, returning a WDL or, given the verdict,
+// a DTZ. DTZ tables only keep one side to move, and report otherSide for the
+// other.
+func (tb *Tablebase) probeTable(board *Board, dtz bool, wdl WDL) (value int, otherSide bool, err error) {
+	if tbCountPieces(board) == 2 {
+		return int(WDLDraw), false, nil
+	}
+
+	white, black := tbMaterial(board, White), tbMaterial(board, Black)
+	ext := ".rtbw"
+	if dtz {
+		ext = ".rtbz"
+	}
+
+	// tables are named with the stronger side first, as white
+	t, swapped := tb.tables[white+"v"+black+ext], false
+	if t == nil {
+		t, swapped = tb.tables[black+"v"+white+ext], true
+	}
+	if t == nil {
+		return 0, false, fmt.Errorf("%w: no %sv%s%s table", ErrNotInTablebase, white, black, ext)
+	}
+	if err := t.open(); err != nil {
+		return 0, false, err
+	}
+
+	// a table for the same pieces on both sides only has white to move
+	flip := swapped || (t.symmetric && board.SideToMove == Black)
+	return t.probe(board, flip, wdl)
+}
+
+func tbCountPieces(board *Board) (n int) {
+	for _, piece := range board.squares {
+		if piece.IsValid() {
+			n++
+		}
+	}
+	return
+}
+
+// The side's pieces the way table names list them, as in "KRP"
+func tbMaterial(board *Board, side SideColor) string {
+	var counts [King + 1]int
+	for _, piece := range board.squares {
+		if piece.Color == side {
+			counts[piece.Name]++
+		}
+	}
+
+	var b strings.Builder
+	for name := King; name >= Pawn; name-- {
+		b.WriteString(strings.Repeat(string("PNBRQK"[name-1]), counts[name]))
+	}
+	return b.String()
+}
+
+func tbMated(board *Board) bool {
+	return board.InCheck(board.SideToMove) && len(board.Moves()) == 0
+}
+
+func tbCapture(move Move) bool {
+	return move.Captures != 0 || move.IsEnPassant
+}
+
+// Zeroing moves reset the halfmove clock
+func tbZeroing(move Move) bool {
+	return move.Moves == Pawn || tbCapture(move)
+}
+
+// The DTZ of a position whose best move zeroes with the verdict after it
+func tbDTZBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case WDLWin:
+		return 1
+	case WDLCursedWin:
+		return 101
+	case WDLBlessedLoss:
+		return -101
+	case WDLLoss:
+		return -1
+	}
+	return 0
+}
+
+func tbSign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+// The Polyglot keys of the positions since the last zeroing move, the
+// current one first
+func tbPositionKeys(board *Board) []uint64 {
+	b := board.Clone()
+	keys := []uint64{b.PolyglotKey()}
+	for len(b.history) > 0 && !tbZeroing(b.history[len(b.history)-1].Move) {
+		b.UnmakeMove()
+		keys = append(keys, b.PolyglotKey())
+	}
+	return keys
+}
+
+// How many times the position has occurred since the last zeroing move
+func tbRepetitions(board *Board) int {
+	keys := tbPositionKeys(board)
+	n := 0
+	for _, key := range keys {
+		if key == keys[0] {
+			n++
+		}
+	}
+	return n
+}
+
+const tbPieces = 7 // the most pieces a table can have
+
+// Flags of a table's header
+const (
+	tbSplit    = 1 // a WDL table has both sides to move
+	tbHasPawns = 2
+)
+
+// Flags of each part of a table
+const (
+	tbSTM         = 1 // the side to move a DTZ table has, black if set
+	tbMapped      = 2 // DTZ values go through a map
+	tbWinPlies    = 4 // DTZ of wins in plies rather than moves
+	tbLossPlies   = 8
+	tbWide        = 16 // maps of 16 bit values
+	tbSingleValue = 128
+)
+
+// A WDL or DTZ table of one ending, read when it's first probed
+type tbTable struct {
+	path            string
+	dtz             bool
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool   // a piece other than a king is the only one of its kind
+	symmetric       bool   // the same pieces on both sides
+	pawns           [2]int // of the leading side, black only if white has none or more, and of the other
+
+	once   sync.Once
+	err    error
+	f      *os.File
+	sides  int            // 2 if a WDL table has both sides to move
+	files  int            // 4 if split by the leading pawn's file, a to d
+	parts  [4][2]*tbPairs // by file and side to move
+	dtzMap []byte         // of all files
+}
+
+// The table for an ending named like "KRPvKR", with white's pieces first
+func newTBTable(name string, dtz bool) (*tbTable, bool) {
+	sides := strings.Split(name, "v")
+	if len(sides) != 2 {
+		return nil, false
+	}
+
+	t := &tbTable{dtz: dtz, symmetric: sides[0] == sides[1]}
+	var counts [2][King + 1]int
+	for i, side := range sides {
+		for _, c := range side {
+			n := strings.IndexRune("PNBRQK", c)
+			if n == -1 {
+				return nil, false
+			}
+			counts[i][n+1]++
+			t.pieceCount++
+		}
+		if counts[i][King] != 1 {
+			return nil, false
+		}
+		for name := Pawn; name < King; name++ {
+			if counts[i][name] == 1 {
+				t.hasUniquePieces = true
+			}
+		}
+	}
+	if t.pieceCount > tbPieces {
+		return nil, false
+	}
+
+	t.hasPawns = counts[0][Pawn]+counts[1][Pawn] > 0
+	lead := 0
+	if counts[0][Pawn] == 0 || (counts[1][Pawn] > 0 && counts[1][Pawn] < counts[0][Pawn]) {
+		lead = 1
+	}
+	t.pawns = [2]int{counts[lead][Pawn], counts[lead^1][Pawn]}
+	return t, true
+}
+
+func (t *tbTable) open() error {
+	t.once.Do(func() {
+		f, err := os.Open(t.path)
+		if err == nil {
+			err = t.read(f)
+		}
+		if err != nil {
+			if f != nil {
+				f.Close()
+			}
+			t.err = fmt.Errorf("%s: %v", t.path, err)
+			return
+		}
+		t.f = f
+	})
+	return t.err
+}
+
+// Reads a table's header in order, keeping the first error
+type tbReader struct {
+	r    io.ReaderAt
+	off  int64
+	size int64
+	err  error
+}
+
+// Reads the next n bytes, nil after an error
+func (r *tbReader) bytes(n int) []byte {
+	if r.err == nil && (n < 0 || r.off+int64(n) > r.size) {
+		r.err = io.ErrUnexpectedEOF
+	}
+	if r.err != nil {
+		r.off += int64(n)
+		return nil
+	}
+
+	b := make([]byte, n)
+	_, r.err = r.r.ReadAt(b, r.off)
+	r.off += int64(n)
+	return b
+}
+
+// Reads a little endian number of n bytes
+func (r *tbReader) number(n int) int {
+	b := make([]byte, 8)
+	copy(b, r.bytes(n))
+	return int(binary.LittleEndian.Uint64(b))
+}
+
+func (r *tbReader) u8() int {
+	return r.number(1)
+}
+
+func (r *tbReader) u16() int {
+	return r.number(2)
+}
+
+func (r *tbReader) u32() int {
+	return r.number(4)
+}
+
+func (r *tbReader) align(n int64) {
+	r.off = (r.off + n - 1) / n * n
+}
+
+var (
+	tbMagicWDL = []byte{0x71, 0xe8, 0x23, 0x5d}
+	tbMagicDTZ = []byte{0xd7, 0x66, 0x0c, 0xa5}
+)
+
+// Reads the header: the order of the pieces and the way each part of the
+// table is compressed, leaving the compressed blocks on disk
+func (t *tbTable) read(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := &tbReader{r: f, size: info.Size()}
+
+	magic := tbMagicWDL
+	if t.dtz {
+		magic = tbMagicDTZ
+	}
+	if !bytes.Equal(r.bytes(4), magic) && r.err == nil {
+		return errors.New("not a Syzygy table")
+	}
+
+	flags := r.u8()
+	if (flags&tbHasPawns != 0) != t.hasPawns || (flags&tbSplit != 0) == t.symmetric {
+		return errors.New("header doesn't match the table's name")
+	}
+	t.sides, t.files = 1, 1
+	if !t.dtz && !t.symmetric {
+		t.sides = 2
+	}
+	if t.hasPawns {
+		t.files = 4
+	}
+
+	bothPawns := t.hasPawns && t.pawns[1] > 0
+	for f := 0; f < t.files; f++ {
+		order, pawnOrder := r.u8(), 0xff
+		if bothPawns {
+			pawnOrder = r.u8()
+		}
+
+		for i := 0; i < t.sides; i++ {
+			t.parts[f][i] = &tbPairs{}
+		}
+		for k := 0; k < t.pieceCount; k++ {
+			b := r.u8()
+			for i := 0; i < t.sides; i++ {
+				t.parts[f][i].pieces[k] = byte(b >> (4 * i) & 0xf)
+			}
+		}
+		for i := 0; i < t.sides; i++ {
+			if r.err == nil && !t.matches(t.parts[f][i].pieces[:t.pieceCount]) {
+				return errors.New("pieces don't match the table's name")
+			}
+			t.parts[f][i].setGroups(t, order>>(4*i)&0xf, pawnOrder>>(4*i)&0xf, f)
+		}
+	}
+	r.align(2)
+
+	t.each(func(d *tbPairs) { d.readSizes(r) })
+	if r.err != nil {
+		return r.err
+	}
+	if t.dtz {
+		t.readDTZMap(r)
+	}
+	t.each(func(d *tbPairs) { d.sparseIndex = r.bytes(6 * d.sparseIndexSize) })
+	t.each(func(d *tbPairs) {
+		b := r.bytes(2 * d.blockLengthSize)
+		if b == nil {
+			return
+		}
+		d.blockLength = make([]int, d.blockLengthSize)
+		for i := range d.blockLength {
+			d.blockLength[i] = int(binary.LittleEndian.Uint16(b[2*i:]))
+		}
+	})
+	if r.err != nil {
+		return r.err
+	}
+
+	// the last block can end short of its size
+	t.each(func(d *tbPairs) {
+		r.align(64)
+		if d.data = r.off; d.blocks > 0 && d.data >= r.size {
+			r.err = io.ErrUnexpectedEOF
+		}
+		r.off += int64(d.blocks) * int64(d.blockSize)
+	})
+	return r.err
+}
+
+func (t *tbTable) each(f func(d *tbPairs)) {
+	for file := 0; file < t.files; file++ {
+		for i := 0; i < t.sides; i++ {
+			f(t.parts[file][i])
+		}
+	}
+}
+
+// Reports whether the piece codes are the ones the table's name has
+func (t *tbTable) matches(pieces []byte) bool {
+	name := strings.TrimSuffix(filepath.Base(t.path), filepath.Ext(t.path))
+	sides := strings.Split(name, "v")
+
+	var want, got [16]int
+	for i, side := range sides {
+		for _, c := range side {
+			want[strings.IndexRune("PNBRQK", c)+1+8*i]++
+		}
+	}
+	for _, p := range pieces {
+		got[p]++
+	}
+	return want == got
+}
+
+func (t *tbTable) readDTZMap(r *tbReader) {
+	start := r.off
+	for f := 0; f < t.files; f++ {
+		d := t.parts[f][0]
+		if d.flags&tbMapped == 0 {
+			continue
+		}
+
+		// four maps, for wins, losses, cursed wins and blessed losses,
+		// each a length and as many values
+		if d.flags&tbWide != 0 {
+			r.align(2)
+			for i := range d.mapIdx {
+				d.mapIdx[i] = int(r.off-start)/2 + 1
+				r.off += 2 * int64(r.u16())
+			}
+		} else {
+			for i := range d.mapIdx {
+				d.mapIdx[i] = int(r.off-start) + 1
+				r.off += int64(r.u8())
+			}
+		}
+	}
+
+	end := r.off
+	r.off = start
+	t.dtzMap = r.bytes(int(end - start))
+	r.align(2)
+}
+
+// Finds the position's value in the table. The table has white as the side
+// listed first in its name, so flip swaps the colors and mirrors the board
+// for positions where black has those pieces.
+func (t *tbTable) probe(board *Board, flip bool, wdl WDL) (int, bool, error) {
+	d, file, stm, idx := t.index(board, flip)
+	if t.dtz && d.flags&tbSTM != stm && !(t.symmetric && !t.hasPawns) {
+		return 0, true, nil
+	}
+
+	value, err := t.value(d, idx)
+	if err != nil {
+		return 0, false, err
+	}
+	if !t.dtz {
+		return value - 2, false, nil
+	}
+	value, err = t.mapDTZ(t.parts[file][0], value, wdl)
+	return value, false, err
+}
+
+// Returns the part of the table that has the position, by the file of its
+// leading pawn and the side to move, and the position's index there
+func (t *tbTable) index(board *Board, flip bool) (d *tbPairs, file, stm int, idx uint64) {
+	var squares [tbPieces]int
+	var pieces [tbPieces]byte
+	flipColor, flipSquares := byte(0), 0
+	if board.SideToMove == Black {
+		stm = 1
+	}
+	if flip {
+		flipColor, flipSquares, stm = 8, 56, stm^1
+	}
+
+	tbIndexOnce.Do(initTablebaseIndex)
+
+	// tables with pawns are split by the file of the leading pawn, the one
+	// of the leading side nearest the edge and then the first rank
+	size, leadPawns := 0, 0
+	var leadPawn byte
+	if t.hasPawns {
+		leadPawn = t.parts[0][0].pieces[0]
+		for sq, piece := range board.squares {
+			if piece.IsValid() && tbPieceCode(piece)^flipColor == leadPawn {
+				squares[size] = sq ^ flipSquares
+				size++
+			}
+		}
+		leadPawns = size
+
+		lead := 0
+		for i := 1; i < leadPawns; i++ {
+			if tbMapPawns[squares[i]] > tbMapPawns[squares[lead]] {
+				lead = i
+			}
+		}
+		squares[0], squares[lead] = squares[lead], squares[0]
+
+		if file = squares[0] & 7; file > 3 {
+			file = 7 - file
+		}
+	}
+
+	for sq, piece := range board.squares {
+		if code := tbPieceCode(piece) ^ flipColor; piece.IsValid() && !(t.hasPawns && code == leadPawn) {
+			squares[size], pieces[size] = sq^flipSquares, code
+			size++
+		}
+	}
+	d = t.parts[file][stm%t.sides]
+
+	// the pieces go in the order the table has them
+	for i := leadPawns; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// then the board is mirrored to put the first piece on files a to d
+	if squares[0]&7 > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 7
+		}
+	}
+
+	if t.hasPawns {
+		idx = tbLeadPawnIdx[leadPawns][squares[0]]
+		lead := squares[1:leadPawns]
+		sort.Slice(lead, func(i, j int) bool { return tbMapPawns[lead[i]] < tbMapPawns[lead[j]] })
+		for i := 1; i < leadPawns; i++ {
+			idx += tbBinomial[i][tbMapPawns[squares[i]]]
+		}
+	} else {
+		// and without pawns to ranks 1 to 4, and below the a1-h8 diagonal
+		// for the first piece of the leading group that's off it
+		if squares[0]>>3 > 3 {
+			for i := 0; i < size; i++ {
+				squares[i] ^= 56
+			}
+		}
+		for i := 0; i < d.groupLen[0]; i++ {
+			off := tbOffDiagonal(squares[i])
+			if off == 0 {
+				continue
+			}
+			if off > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = (squares[j]>>3 | squares[j]<<3) & 63
+				}
+			}
+			break
+		}
+
+		if t.hasUniquePieces {
+			idx = tbUniqueIdx(squares[0], squares[1], squares[2])
+		} else {
+			idx = uint64(tbMapKK[tbMapA1D1D4[squares[0]]][squares[1]])
+		}
+	}
+
+	// the other groups of like pieces are each a combination of the squares
+	// the groups before them leave free
+	idx *= d.groupIdx[0]
+	start := d.groupLen[0]
+	otherPawns := t.hasPawns && t.pawns[1] > 0
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		sort.Ints(group)
+
+		var n uint64
+		for i, sq := range group {
+			free := sq
+			for _, s := range squares[:start] {
+				if sq > s {
+					free--
+				}
+			}
+			if otherPawns {
+				free -= 8
+			}
+			n += tbBinomial[i+1][free]
+		}
+
+		otherPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+	return d, file, stm, idx
+}
+
+// Turns a DTZ table value into plies
+func (t *tbTable) mapDTZ(d *tbPairs, value int, wdl WDL) (int, error) {
+	if d.flags&tbMapped != 0 {
+		i := d.mapIdx[[...]int{1, 3, 0, 2, 0}[wdl+2]] + value
+		if d.flags&tbWide != 0 {
+			if 2*i+2 > len(t.dtzMap) {
+				return 0, errors.New("DTZ map out of range")
+			}
+			value = int(binary.LittleEndian.Uint16(t.dtzMap[2*i:]))
+		} else {
+			if i >= len(t.dtzMap) {
+				return 0, errors.New("DTZ map out of range")
+			}
+			value = int(t.dtzMap[i])
+		}
+	}
+
+	if (wdl == WDLWin && d.flags&tbWinPlies == 0) || (wdl == WDLLoss && d.flags&tbLossPlies == 0) ||
+		wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+		value *= 2
+	}
+	return value + 1, nil
+}
+
+// The values of one side to move and file of a table, compressed by
+// recursive pairing and then a canonical Huffman code into blocks
+type tbPairs struct {
+	flags    int
+	pieces   [tbPieces]byte
+	groupLen [tbPieces + 1]int    // lengths of the groups of like pieces, ending in 0
+	groupIdx [tbPieces + 1]uint64 // what each group's index counts for, then the size
+
+	blockSize       int
+	span            uint64 // values per sparse index entry
+	sparseIndexSize int
+	blocks          int
+	blockLengthSize int
+	minSymLen       int // or the only value of a single value table
+	lowestSym       []int
+	base64          []uint64
+	symlen          []int  // values each symbol stands for, less one
+	btree           []byte // the two symbols each one pairs, 12 bits each
+
+	sparseIndex []byte
+	blockLength []int
+	data        int64 // where the blocks start in the file
+	mapIdx      [4]int
+}
+
+// Groups the pieces: the leading group first, the pawns of the leading side
+// or the kings and any other unique piece, then pieces of a kind together.
+// order and pawnOrder say where the leading group and the other side's pawns
+// come in the index.
+func (d *tbPairs) setGroups(t *tbTable, order, pawnOrder, file int) {
+	tbIndexOnce.Do(initTablebaseIndex)
+
+	n, firstLen := 0, 2
+	if t.hasPawns {
+		firstLen = 0
+	} else if t.hasUniquePieces {
+		firstLen = 3
+	}
+	d.groupLen[n] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		if firstLen--; firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	bothPawns := t.hasPawns && t.pawns[1] > 0
+	next, free := 1, 64-d.groupLen[0]
+	if bothPawns {
+		next, free = 2, free-d.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order || k == pawnOrder; k++ {
+		switch {
+		case k == order:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= tbLeadPawnsSize[d.groupLen[0]][file]
+			case t.hasUniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case k == pawnOrder:
+			d.groupIdx[1] = idx
+			idx *= tbBinomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= tbBinomial[d.groupLen[next]][free]
+			free -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+func (d *tbPairs) size() uint64 {
+	for i, n := range d.groupLen {
+		if n == 0 {
+			return d.groupIdx[i]
+		}
+	}
+	return 0
+}
+
+func (d *tbPairs) readSizes(r *tbReader) {
+	if d.flags = r.u8(); d.flags&tbSingleValue != 0 {
+		d.minSymLen = r.u8()
+		return
+	}
+
+	d.blockSize = 1 << r.u8()
+	d.span = 1 << r.u8()
+	d.sparseIndexSize = int((d.size() + d.span - 1) / d.span)
+	padding := r.u8()
+	d.blocks = r.u32()
+	d.blockLengthSize = d.blocks + padding
+	maxSymLen := r.u8()
+	d.minSymLen = r.u8()
+	if r.err != nil {
+		return
+	}
+	if d.minSymLen < 1 || maxSymLen < d.minSymLen || maxSymLen > 32 || d.blockSize < 8 {
+		r.err = errors.New("bad Huffman code")
+		return
+	}
+
+	// the code is canonical with the longest codes lowest, and lowestSym
+	// the first symbol of each length, from the shortest
+	n := maxSymLen - d.minSymLen + 1
+	d.lowestSym = make([]int, n)
+	for i := range d.lowestSym {
+		d.lowestSym[i] = r.u16()
+	}
+	d.base64 = make([]uint64, n)
+	for i := n - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + uint64(d.lowestSym[i]) - uint64(d.lowestSym[i+1])) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= 64 - i - d.minSymLen
+	}
+
+	syms := r.u16()
+	d.btree = r.bytes(3 * syms)
+	if syms&1 != 0 {
+		r.bytes(1)
+	}
+	if r.err != nil {
+		return
+	}
+
+	d.symlen = make([]int, syms)
+	visited := make([]bool, syms)
+	for s := range d.symlen {
+		if !visited[s] {
+			d.symlen[s] = d.setSymlen(s, visited, r)
+		}
+	}
+}
+
+// The symbols a symbol pairs
+func (d *tbPairs) left(s int) int {
+	return int(d.btree[3*s+1]&0xf)<<8 | int(d.btree[3*s])
+}
+
+func (d *tbPairs) right(s int) int {
+	return int(d.btree[3*s+2])<<4 | int(d.btree[3*s+1]>>4)
+}
+
+// Counts the values a symbol stands for, less one
+func (d *tbPairs) setSymlen(s int, visited []bool, r *tbReader) int {
+	visited[s] = true
+	right := d.right(s)
+	if right == 0xfff {
+		return 0 // a value
+	}
+
+	left := d.left(s)
+	if left >= len(d.symlen) || right >= len(d.symlen) {
+		r.err = errors.New("bad symbol pair")
+		return 0
+	}
+	if !visited[left] {
+		d.symlen[left] = d.setSymlen(left, visited, r)
+	}
+	if !visited[right] {
+		d.symlen[right] = d.setSymlen(right, visited, r)
+	}
+	return d.symlen[left] + d.symlen[right] + 1
+}
+
+// Decompresses the value at the index: the sparse index finds a block near
+// it, the block lengths the block that has it, and then the block's symbols
+// are decoded up to it
+func (t *tbTable) value(d *tbPairs, idx uint64) (int, error) {
+	if d.flags&tbSingleValue != 0 {
+		return d.minSymLen, nil
+	}
+
+	corrupt := fmt.Errorf("%s: corrupt table", t.path)
+	k := idx / d.span
+	if k >= uint64(d.sparseIndexSize) {
+		return 0, corrupt
+	}
+	block := int(binary.LittleEndian.Uint32(d.sparseIndex[6*k:]))
+	offset := int(binary.LittleEndian.Uint16(d.sparseIndex[6*k+4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+
+	for offset < 0 {
+		if block--; block < 0 || block >= len(d.blockLength) {
+			return 0, corrupt
+		}
+		offset += d.blockLength[block] + 1
+	}
+	for block < len(d.blockLength) && offset > d.blockLength[block] {
+		offset -= d.blockLength[block] + 1
+		block++
+	}
+	if block >= d.blocks || block >= len(d.blockLength) {
+		return 0, corrupt
+	}
+
+	// a block can end the file, so what's missing past it reads as zeros
+	data := make([]byte, d.blockSize+16)
+	if _, err := t.f.ReadAt(data, d.data+int64(block)*int64(d.blockSize)); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	buf := binary.BigEndian.Uint64(data)
+	data = data[8:]
+	bits := 64
+	var sym int
+	for {
+		n := 0
+		for buf < d.base64[n] {
+			n++
+		}
+		sym = int((buf-d.base64[n])>>(64-n-d.minSymLen)) + d.lowestSym[n]
+		if sym >= len(d.symlen) {
+			return 0, corrupt
+		}
+		if offset <= d.symlen[sym] {
+			break
+		}
+
+		offset -= d.symlen[sym] + 1
+		n += d.minSymLen
+		buf <<= n
+		if bits -= n; bits <= 32 {
+			if len(data) < 4 {
+				return 0, corrupt
+			}
+			bits += 32
+			buf |= uint64(binary.BigEndian.Uint32(data)) << (64 - bits)
+			data = data[4:]
+		}
+	}
+
+	// the symbol stands for several values, found down the pairs it made
+	for d.symlen[sym] != 0 {
+		left := d.left(sym)
+		if offset <= d.symlen[left] {
+			sym = left
+		} else {
+			offset -= d.symlen[left] + 1
+			sym = d.right(sym)
+		}
+	}
+	return d.left(sym), nil
+}
+
+// Piece codes of the tables: 1 to 6 for white's pawn to king, 9 to 14 for
+// black's
+func tbPieceCode(piece Piece) byte {
+	code := byte(piece.Name)
+	if piece.Color == Black {
+		code |= 8
+	}
+	return code
+}
+
+// Whether the square is above (positive) or below the a1-h8 diagonal
+func tbOffDiagonal(sq int) int {
+	return sq>>3 - sq&7
+}
+
+var (
+	tbIndexOnce     sync.Once
+	tbBinomial      [tbPieces][64]uint64
+	tbMapPawns      [64]int
+	tbLeadPawnIdx   [tbPieces][64]uint64
+	tbLeadPawnsSize [tbPieces][4]uint64
+	tbMapB1H1H7     [64]int
+	tbMapA1D1D4     [64]int
+	tbMapKK         [10][64]int
+)
+
+// Builds the tables that index positions the way Syzygy tables do
+func initTablebaseIndex() {
+	for n := 0; n < 64; n++ {
+		tbBinomial[0][n] = 1
+		for k := 1; k < tbPieces && n > 0; k++ {
+			tbBinomial[k][n] = tbBinomial[k-1][n-1] + tbBinomial[k][n-1]
+		}
+	}
+
+	// the squares below the diagonal, and those of the a1-d1-d4 triangle with
+	// the diagonal's last
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if tbOffDiagonal(sq) < 0 {
+			tbMapB1H1H7[sq] = code
+			code++
+		}
+	}
+	code = 0
+	var diagonal []int
+	for sq := 0; sq < 28; sq++ {
+		if sq&7 > 3 {
+			continue
+		}
+		if off := tbOffDiagonal(sq); off < 0 {
+			tbMapA1D1D4[sq] = code
+			code++
+		} else if off == 0 {
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		tbMapA1D1D4[sq] = code
+		code++
+	}
+
+	// the 462 placements of two kings with the first in the triangle, and
+	// the second not above the diagonal if the first is on it, with both on
+	// the diagonal last
+	var bothOnDiagonal [][2]int
+	code = 0
+	for i := 0; i < 10; i++ {
+		for k1 := 0; k1 < 28; k1++ {
+			if tbMapA1D1D4[k1] != i || (i == 0 && k1 != 1) {
+				continue
+			}
+			for k2 := 0; k2 < 64; k2++ {
+				switch df, dr := k1&7-k2&7, k1>>3-k2>>3; {
+				case df >= -1 && df <= 1 && dr >= -1 && dr <= 1:
+				case tbOffDiagonal(k1) == 0 && tbOffDiagonal(k2) > 0:
+				case tbOffDiagonal(k1) == 0 && tbOffDiagonal(k2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, [2]int{i, k2})
+				default:
+					tbMapKK[i][k2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, kk := range bothOnDiagonal {
+		tbMapKK[kk[0]][kk[1]] = code
+		code++
+	}
+
+	// pawns count from a2 and h2 inward and up, so the leading pawn has the
+	// highest, and each leading pawn square indexes the placements of the
+	// other leading pawns on the squares below it
+	available := 47
+	for lead := 1; lead < tbPieces; lead++ {
+		for file := 0; file < 4; file++ {
+			var idx uint64
+			for rank := 1; rank < 7; rank++ {
+				sq := rank*8 + file
+				if lead == 1 {
+					tbMapPawns[sq] = available
+					tbMapPawns[sq^7] = available - 1
+					available -= 2
+				}
+				tbLeadPawnIdx[lead][sq] = idx
+				idx += tbBinomial[lead-1][tbMapPawns[sq]]
+			}
+			tbLeadPawnsSize[lead][file] = idx
+		}
+	}
+}
+
+// Indexes three unique pieces, the first in the a1-d1-d4 triangle and the
+// first off the diagonal below it
+func tbUniqueIdx(s0, s1, s2 int) uint64 {
+	adjust1, adjust2 := 0, 0
+	if s1 > s0 {
+		adjust1++
+	}
+	if s2 > s0 {
+		adjust2++
+	}
+	if s2 > s1 {
+		adjust2++
+	}
+
+	switch {
+	case tbOffDiagonal(s0) != 0:
+		return uint64((tbMapA1D1D4[s0]*63+s1-adjust1)*62 + s2 - adjust2)
+	case tbOffDiagonal(s1) != 0:
+		return uint64((6*63+(s0>>3)*28+tbMapB1H1H7[s1])*62 + s2 - adjust2)
+	case tbOffDiagonal(s2) != 0:
+		return uint64(6*63*62 + 4*28*62 + (s0>>3)*7*28 + (s1>>3-adjust1)*28 + tbMapB1H1H7[s2])
+	default:
+		return uint64(6*63*62 + 4*28*62 + 4*7*28 + (s0>>3)*7*6 + (s1>>3-adjust1)*6 + s2>>3 - adjust2)
+	}
+}
@@ -0,0 +1,472 @@
+package chess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an engine has to answer uci, isready and quit
+const uciTimeout = 10 * time.Second
+
+// An option declared by a UCI engine
+type UCIOption struct {
+	Name     string
+	Type     string // check, spin, combo, button or string
+	Default  string
+	Min, Max int
+	Vars     []string
+}
+
+// A UCI engine run as a subprocess. Positions and moves are exchanged as
+// Boards and Moves; the engine's own notation never leaves the type.
+type UCIEngine struct {
+	Name    string
+	Author  string
+	Options map[string]UCIOption // keyed by lowercase name, UCI option names are case insensitive
+
+	// Now tells the time Go reports a search took and After waits out the
+	// timeouts on the engine's replies, time.Now and time.After if they're
+	// nil, so tests can control them once the engine has started
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+	board *Board // the position last sent, which the engine's moves are read against
+
+	mu sync.Mutex // serializes commands, so Stop can be sent while Go waits
+}
+
+// StartUCIEngine launches the engine and waits for it to identify itself and
+// declare its options
+func StartUCIEngine(path string, args ...string) (*UCIEngine, error) {
+	e := &UCIEngine{
+		Options: make(map[string]UCIOption),
+		cmd:     exec.Command(path, args...),
+		lines:   make(chan string, 64),
+		board:   StartingPosition(),
+	}
+
+	var err error
+	if e.stdin, err = e.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			e.lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(e.lines)
+	}()
+
+	e.send("uci")
+	err = e.readUntil("uciok", uciTimeout, func(line string) {
+		switch {
+		case strings.HasPrefix(line, "id name "):
+			e.Name = strings.TrimPrefix(line, "id name ")
+		case strings.HasPrefix(line, "id author "):
+			e.Author = strings.TrimPrefix(line, "id author ")
+		case strings.HasPrefix(line, "option "):
+			if opt, ok := parseUCIOption(line); ok {
+				e.Options[strings.ToLower(opt.Name)] = opt
+			}
+		}
+	})
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Parses a line such as "option name Hash type spin default 16 min 1 max 1024"
+func parseUCIOption(line string) (UCIOption, bool) {
+	opt := UCIOption{}
+	fields := strings.Fields(line)[1:]
+
+	// names and values may contain spaces, so each runs until the next keyword
+	keywords := map[string]bool{"name": true, "type": true, "default": true, "min": true, "max": true, "var": true}
+	for i := 0; i < len(fields); {
+		key := fields[i]
+		j := i + 1
+		for j < len(fields) && !keywords[fields[j]] {
+			j++
+		}
+		value := strings.Join(fields[i+1:j], " ")
+		if value == "<empty>" {
+			value = ""
+		}
+
+		switch key {
+		case "name":
+			opt.Name = value
+		case "type":
+			opt.Type = value
+		case "default":
+			opt.Default = value
+		case "min":
+			opt.Min, _ = strconv.Atoi(value)
+		case "max":
+			opt.Max, _ = strconv.Atoi(value)
+		case "var":
+			opt.Vars = append(opt.Vars, value)
+		}
+		i = j
+	}
+
+	return opt, opt.Name != "" && opt.Type != ""
+}
+
+// SetOption sets one of the options the engine declared. Values are checked
+// against the option's type; buttons take no value.
+func (e *UCIEngine) SetOption(name, value string) error {
+	opt, ok := e.Options[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown UCI option %q", name)
+	}
+
+	switch opt.Type {
+	case "button":
+		return e.send("setoption name " + opt.Name)
+	case "check":
+		if value != "true" && value != "false" {
+			return fmt.Errorf("UCI option %s: %q is not true or false", opt.Name, value)
+		}
+	case "spin":
+		if n, err := strconv.Atoi(value); err != nil || n < opt.Min || n > opt.Max {
+			return fmt.Errorf("UCI option %s: %q is not in [%d, %d]", opt.Name, value, opt.Min, opt.Max)
+		}
+	case "combo":
+		found := false
+		for _, v := range opt.Vars {
+			found = found || strings.EqualFold(v, value)
+		}
+		if !found {
+			return fmt.Errorf("UCI option %s: %q is not one of %v", opt.Name, value, opt.Vars)
+		}
+	}
+	return e.send("setoption name " + opt.Name + " value " + value)
+}
+
+// IsReady waits for the engine to finish processing the commands sent to it
+func (e *UCIEngine) IsReady() error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	return e.readUntil("readyok", uciTimeout, nil)
+}
+
+func (e *UCIEngine) NewGame() error {
+	if err := e.send("ucinewgame"); err != nil {
+		return err
+	}
+	return e.IsReady()
+}
+
+// SetPosition sends the board's starting position and the moves played from
+// it, so the engine knows the history for repetitions
+func (e *UCIEngine) SetPosition(board *Board) error {
+	start := board.Clone()
+	for len(start.history) > 0 {
+		start.UnmakeMove()
+	}
+
+	buf := strings.Builder{}
+	if fen := start.String(); fen == StartingPosition().String() {
+		buf.WriteString("position startpos")
+	} else {
+		buf.WriteString("position fen " + fen)
+	}
+	if len(board.history) > 0 {
+		buf.WriteString(" moves")
+		for _, state := range board.history {
+			buf.WriteString(" " + state.Move.UCI())
+		}
+	}
+
+	e.board = board.Clone()
+	return e.send(buf.String())
+}
+
+// Go searches the position last set and returns the engine's best move, with
+// the score, depth and PV of the last info line about each line. Each info
+// line is also passed to info if it's non-nil. Without limits, or with
+// Infinite, the engine searches until Stop, and with Ponder it searches
+// until PonderHit or Stop. The engine's MultiPV option, if it has one, is set
+// to the number of lines on every search, so one search's lines don't carry
+// over to the next.
+func (e *UCIEngine) Go(limits SearchLimits, info func(SearchInfo)) (SearchResult, error) {
+	start := e.now()
+	if _, ok := e.Options["multipv"]; ok {
+		multiPV := limits.MultiPV
+		if multiPV < 1 {
+			multiPV = 1
+		}
+		if err := e.SetOption("MultiPV", strconv.Itoa(multiPV)); err != nil {
+			return SearchResult{}, err
+		}
+	}
+	if err := e.send(uciGoCommand(limits)); err != nil {
+		return SearchResult{}, err
+	}
+
+	result := SearchResult{}
+	lines := make(map[int]SearchLine)
+	var best, ponder string
+	err := e.readUntil("bestmove", 0, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		switch fields[0] {
+		case "info":
+			i := parseUCIInfo(fields[1:], e.board)
+			if len(i.PV) > 0 {
+				lines[i.MultiPV] = SearchLine{i.Score, i.PV}
+				if i.MultiPV <= 1 {
+					result.Score, result.Depth, result.PV = i.Score, i.Depth, i.PV
+				}
+			}
+			if i.Nodes > 0 {
+				result.Nodes = i.Nodes
+			}
+			if info != nil {
+				info(i)
+			}
+		case "bestmove":
+			if len(fields) > 1 {
+				best = fields[1]
+			}
+			if len(fields) > 3 && fields[2] == "ponder" {
+				ponder = fields[3]
+			}
+		}
+	})
+	result.Time = e.now().Sub(start)
+	if err != nil {
+		return result, err
+	}
+
+	// engines searching one line may leave out multipv, making it line 0
+	for n := 0; n <= len(lines); n++ {
+		if line, ok := lines[n]; ok {
+			result.Lines = append(result.Lines, line)
+		}
+	}
+
+	if best == "" || best == "0000" || best == "(none)" {
+		return result, nil // no legal moves
+	}
+	if result.Move, err = NewUCIMove(best, e.board); err != nil {
+		return result, err
+	}
+
+	after := e.board.Child(result.Move)
+	result.Ponder, _ = NewUCIMove(ponder, &after)
+	return result, nil
+}
+
+func uciGoCommand(limits SearchLimits) string {
+	if limits.Infinite {
+		return "go infinite"
+	}
+
+	cmd := "go"
+	if limits.Ponder {
+		cmd += " ponder"
+	}
+	if limits.Depth > 0 {
+		cmd += " depth " + strconv.Itoa(limits.Depth)
+	}
+	if limits.Nodes > 0 {
+		cmd += " nodes " + strconv.Itoa(limits.Nodes)
+	}
+	if limits.MoveTime > 0 {
+		cmd += " movetime " + strconv.FormatInt(limits.MoveTime.Milliseconds(), 10)
+	}
+	if cmd == "go" {
+		cmd += " infinite"
+	}
+	return cmd
+}
+
+var uciInfoKeywords = map[string]bool{
+	"depth": true, "seldepth": true, "time": true, "nodes": true, "pv": true, "multipv": true, "score": true,
+	"currmove": true, "currmovenumber": true, "hashfull": true, "nps": true, "tbhits": true, "sbhits": true,
+	"cpuload": true, "string": true, "refutation": true, "currline": true,
+}
+
+// Parses the fields of an info line after "info". PV moves that aren't
+// legal in the position end the PV.
+func parseUCIInfo(fields []string, board *Board) (info SearchInfo) {
+	number := func(i int) int {
+		if i >= len(fields) {
+			return 0
+		}
+		n, _ := strconv.Atoi(fields[i])
+		return n
+	}
+
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "depth":
+			i++
+			info.Depth = number(i)
+		case "seldepth":
+			i++
+			info.SelDepth = number(i)
+		case "multipv":
+			i++
+			info.MultiPV = number(i)
+		case "nodes":
+			i++
+			info.Nodes = number(i)
+		case "nps":
+			i++
+			info.NPS = number(i)
+		case "time":
+			i++
+			info.Time = time.Duration(number(i)) * time.Millisecond
+		case "score":
+			for done := false; !done && i+1 < len(fields); {
+				switch fields[i+1] {
+				case "cp":
+					info.Score = Score(number(i + 2))
+					i += 2
+				case "mate":
+					info.Score = MateIn(number(i + 2))
+					i += 2
+				case "lowerbound":
+					info.LowerBound = true
+					i++
+				case "upperbound":
+					info.UpperBound = true
+					i++
+				default:
+					done = true
+				}
+			}
+		case "pv":
+			replay := board.Clone()
+			for i+1 < len(fields) && !uciInfoKeywords[fields[i+1]] {
+				i++
+				if replay == nil {
+					continue
+				}
+				move, err := NewUCIMove(fields[i], replay)
+				if err != nil {
+					replay = nil
+					continue
+				}
+				replay.MakeMove(move)
+				info.PV = append(info.PV, move)
+			}
+		case "string":
+			info.String = strings.Join(fields[i+1:], " ")
+			return
+		default:
+			// currmove, hashfull and the like, and the moves of refutation
+			// and currline, aren't kept
+			for i+1 < len(fields) && !uciInfoKeywords[fields[i+1]] {
+				i++
+			}
+		}
+	}
+	return
+}
+
+// Stop tells the engine to end the search Go is waiting on
+func (e *UCIEngine) Stop() error {
+	return e.send("stop")
+}
+
+// PonderHit tells the engine pondering in Go that the expected move was
+// played, so it should search within the limits from now on
+func (e *UCIEngine) PonderHit() error {
+	return e.send("ponderhit")
+}
+
+// Close asks the engine to quit, killing it if it doesn't in time
+func (e *UCIEngine) Close() error {
+	e.send("quit")
+	e.stdin.Close()
+
+	timeout := e.after(uciTimeout)
+	for {
+		select {
+		case _, ok := <-e.lines:
+			if !ok {
+				return e.cmd.Wait()
+			}
+		case <-timeout:
+			e.cmd.Process.Kill()
+			for range e.lines {
+			}
+			return e.cmd.Wait()
+		}
+	}
+}
+
+func (e *UCIEngine) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+func (e *UCIEngine) after(d time.Duration) <-chan time.Time {
+	if e.After != nil {
+		return e.After(d)
+	}
+	return time.After(d)
+}
+
+func (e *UCIEngine) send(command string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := io.WriteString(e.stdin, command+"\n")
+	return err
+}
+
+// Reads lines until one starting with the command, passing each to fn if
+// it's non-nil. A timeout of 0 waits as long as the engine runs.
+func (e *UCIEngine) readUntil(command string, timeout time.Duration, fn func(string)) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = e.after(timeout)
+	}
+
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return fmt.Errorf("UCI engine exited waiting for %s", command)
+			}
+			if fn != nil {
+				fn(line)
+			}
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == command {
+				return nil
+			}
+		case <-expired:
+			return fmt.Errorf("no %s from UCI engine after %v", command, timeout)
+		}
+	}
+}